@@ -75,3 +75,18 @@ func (r *RedLock) Unlock() {
 			return 0
 		end`, []string{r.Key}, r.Value)
 }
+
+// Renew 延长锁的过期时间，锁已经过期或者被其他人持有时返回false
+func (r *RedLock) Renew(ttl time.Duration) bool {
+	client, index := redis.GetInstance().GetRedisClientByType(redis.RedisTypePlayer)
+	if index == 0 {
+		return false
+	}
+	ret, err := client.EvalLua(`
+		if redis.call("get", KEYS[1]) == ARGV[1] then
+			return redis.call("pexpire", KEYS[1], ARGV[2])
+		else
+			return 0
+		end`, []string{r.Key}, r.Value, ttl.Milliseconds())
+	return err == nil && ret == int64(1)
+}
@@ -0,0 +1,369 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 指标注册和Prometheus文本格式输出，只依赖标准库
+// 使用方法：
+//
+//	var handlerErrors = metrics.NewCounterVec("pp_handler_errors_total", "消息处理错误数", "msgid", "reason")
+//	handlerErrors.With("1001", "panic").Inc()
+//
+// 同一个指标名只能注册一次，重复注册返回已注册的指标
+
+// 指标类型
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefBuckets 默认的耗时分桶(秒)
+var DefBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type metric interface {
+	desc() *metricDesc
+	write(w *bufio.Writer)
+}
+
+type metricDesc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+var (
+	registry      = make(map[string]metric)
+	registryMutex sync.RWMutex
+)
+
+func init() {
+	NewGaugeFunc("pp_goroutines", "当前协程数", func() float64 { return float64(runtime.NumGoroutine()) })
+}
+
+// register 注册指标，已经注册过同名指标时返回已注册的
+func register(m metric) metric {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if old, ok := registry[m.desc().name]; ok {
+		return old
+	}
+	registry[m.desc().name] = m
+	return m
+}
+
+// WriteText 按Prometheus文本格式输出所有指标，按指标名排序
+func WriteText(w io.Writer) error {
+	registryMutex.RLock()
+	metricList := make([]metric, 0, len(registry))
+	for _, m := range registry {
+		metricList = append(metricList, m)
+	}
+	registryMutex.RUnlock()
+	sort.Slice(metricList, func(i, j int) bool { return metricList[i].desc().name < metricList[j].desc().name })
+
+	writer := bufio.NewWriter(w)
+	for _, m := range metricList {
+		desc := m.desc()
+		fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", desc.name, escapeHelp(desc.help), desc.name, desc.typ)
+		m.write(writer)
+	}
+	return writer.Flush()
+}
+
+// labelString 生成{k="v",...}，没有标签时为空
+func labelString(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteString(",")
+		}
+		builder.WriteString(name)
+		builder.WriteString(`="`)
+		if i < len(values) {
+			builder.WriteString(escapeLabel(values[i]))
+		}
+		builder.WriteString(`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if len(names) > 0 || i > 0 {
+			builder.WriteString(",")
+		}
+		builder.WriteString(extra[i])
+		builder.WriteString(`="`)
+		builder.WriteString(escapeLabel(extra[i+1]))
+		builder.WriteString(`"`)
+	}
+	builder.WriteString("}")
+	return builder.String()
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// atomicFloat 用uint64保存的float64，支持原子加
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		if atomic.CompareAndSwapUint64(&f.bits, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(value float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(value))
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// vec 按标签值保存子指标
+type vec[T any] struct {
+	metricDesc
+	children sync.Map // 标签值用\xff连接 -> *child[T]
+	newValue func() *T
+}
+
+type child[T any] struct {
+	labelValues []string
+	value       *T
+}
+
+func (v *vec[T]) with(labelValues []string) *T {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics %s: expect %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	if c, ok := v.children.Load(key); ok {
+		return c.(*child[T]).value
+	}
+	c, _ := v.children.LoadOrStore(key, &child[T]{labelValues: append([]string{}, labelValues...), value: v.newValue()})
+	return c.(*child[T]).value
+}
+
+// sortedChildren 按标签值排序，输出稳定
+func (v *vec[T]) sortedChildren() []*child[T] {
+	children := make([]*child[T], 0)
+	v.children.Range(func(key, value interface{}) bool {
+		children = append(children, value.(*child[T]))
+		return true
+	})
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].labelValues, "\xff") < strings.Join(children[j].labelValues, "\xff")
+	})
+	return children
+}
+
+func (v *vec[T]) desc() *metricDesc {
+	return &v.metricDesc
+}
+
+// Counter 只增不减的计数
+type Counter struct {
+	value atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add 增加计数，delta不能为负数
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.value.Add(delta)
+}
+
+func (c *Counter) Value() float64 {
+	return c.value.Load()
+}
+
+// CounterVec 带标签的计数
+type CounterVec struct {
+	vec[Counter]
+}
+
+// NewCounterVec 注册带标签的计数
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec[Counter]{metricDesc: metricDesc{name: name, help: help, typ: TypeCounter, labels: labels}, newValue: func() *Counter { return &Counter{} }}}
+	return register(c).(*CounterVec)
+}
+
+// With 按标签值获取计数，标签值个数必须和注册时一致
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.with(labelValues)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	for _, child := range c.sortedChildren() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, child.labelValues), formatFloat(child.value.Value()))
+	}
+}
+
+// Gauge 可增可减的当前值
+type Gauge struct {
+	value atomicFloat
+}
+
+func (g *Gauge) Set(value float64) {
+	g.value.Set(value)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.value.Add(delta)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.value.Load()
+}
+
+// GaugeVec 带标签的当前值
+type GaugeVec struct {
+	vec[Gauge]
+}
+
+// NewGaugeVec 注册带标签的当前值
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec[Gauge]{metricDesc: metricDesc{name: name, help: help, typ: TypeGauge, labels: labels}, newValue: func() *Gauge { return &Gauge{} }}}
+	return register(g).(*GaugeVec)
+}
+
+// With 按标签值获取当前值，标签值个数必须和注册时一致
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.with(labelValues)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	for _, child := range g.sortedChildren() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelString(g.labels, child.labelValues), formatFloat(child.value.Value()))
+	}
+}
+
+// Histogram 分桶统计
+type Histogram struct {
+	buckets []float64
+	counts  []uint64 // 每个桶的计数，不累计
+	count   uint64
+	sum     atomicFloat
+}
+
+// Observe 记录一个值
+func (h *Histogram) Observe(value float64) {
+	index := sort.SearchFloat64s(h.buckets, value)
+	if index < len(h.counts) {
+		atomic.AddUint64(&h.counts[index], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(value)
+}
+
+// HistogramVec 带标签的分桶统计
+type HistogramVec struct {
+	vec[Histogram]
+	buckets []float64
+}
+
+// NewHistogramVec 注册带标签的分桶统计，buckets为空时使用DefBuckets
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.vec = vec[Histogram]{metricDesc: metricDesc{name: name, help: help, typ: TypeHistogram, labels: labels}, newValue: func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	}}
+	return register(h).(*HistogramVec)
+}
+
+// With 按标签值获取分桶统计，标签值个数必须和注册时一致
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.with(labelValues)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	for _, child := range h.sortedChildren() {
+		var cumulative uint64
+		for i, bucket := range h.buckets {
+			cumulative += atomic.LoadUint64(&child.value.counts[i])
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, child.labelValues, "le", formatFloat(bucket)), cumulative)
+		}
+		count := atomic.LoadUint64(&child.value.count)
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, child.labelValues, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, child.labelValues), formatFloat(child.value.sum.Load()))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, child.labelValues), count)
+	}
+}
+
+// collector 输出时调用函数获取值，用于连接池状态等已经在别处统计的数据
+type collector struct {
+	metricDesc
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// NewGaugeFunc 注册不带标签的当前值，输出时调用fn获取
+func NewGaugeFunc(name, help string, fn func() float64) {
+	NewCollector(name, help, TypeGauge, nil, func(emit func(value float64, labelValues ...string)) {
+		emit(fn())
+	})
+}
+
+// NewCollector 注册输出时才获取值的指标，collect中对每组标签值调用emit，typ为TypeCounter或者TypeGauge
+func NewCollector(name, help, typ string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	register(&collector{metricDesc: metricDesc{name: name, help: help, typ: typ, labels: labels}, collect: collect})
+}
+
+func (c *collector) desc() *metricDesc {
+	return &c.metricDesc
+}
+
+func (c *collector) write(w *bufio.Writer) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Fprintf(w, "# collect %s failed: %v\n", c.name, err)
+		}
+	}()
+	c.collect(func(value float64, labelValues ...string) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, labelValues), formatFloat(value))
+	})
+}
@@ -0,0 +1,230 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 导出到本地文件，每行一个OpenTelemetry OTLP/JSON格式的TracesData，可以用otel collector的filelog或者otlpjsonfile接收
+
+const (
+	exportBatchSize = 512
+	exportInterval  = time.Second
+	exportQueueSize = 8192
+)
+
+type fileExporter struct {
+	serviceName string
+	serviceID   string
+	file        *os.File
+	queue       chan *Span    // 等待导出的span，不会关闭，关闭后export仍然可能放入
+	dropped     uint64
+	stop        chan struct{} // 关闭通知
+	done        chan struct{}
+}
+
+var (
+	exporter      atomic.Pointer[fileExporter]
+	exporterMutex sync.Mutex
+)
+
+// StartFileExporter 开始导出到文件，已经开启时先关闭旧的
+func StartFileExporter(path, serviceName, serviceID string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	e := &fileExporter{serviceName: serviceName, serviceID: serviceID, file: file,
+		queue: make(chan *Span, exportQueueSize), stop: make(chan struct{}), done: make(chan struct{})}
+	if old := exporter.Swap(e); old != nil {
+		old.close()
+	}
+	go e.run()
+	return nil
+}
+
+// StopExporter 停止导出，写完队列中的span
+func StopExporter() {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	if old := exporter.Swap(nil); old != nil {
+		old.close()
+	}
+}
+
+// Enabled 是否开启了导出
+func Enabled() bool {
+	return exporter.Load() != nil
+}
+
+// export 没有开启导出时不处理，队列满时丢弃
+func export(span *Span) {
+	e := exporter.Load()
+	if e == nil {
+		return
+	}
+	select {
+	case e.queue <- span:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// close 通知导出协程写完队列中的span后退出，关闭后放入队列的span不再导出
+func (e *fileExporter) close() {
+	close(e.stop)
+	<-e.done
+	_ = e.file.Close()
+}
+
+func (e *fileExporter) run() {
+	defer close(e.done)
+	writer := bufio.NewWriter(e.file)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, exportBatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			if err := e.write(writer, batch); err != nil {
+				fmt.Println("trace export failed,", err)
+			}
+			batch = batch[:0]
+		}
+		if dropped := atomic.SwapUint64(&e.dropped, 0); dropped > 0 {
+			fmt.Println("trace export queue full, dropped spans:", dropped)
+		}
+		_ = writer.Flush()
+	}
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+				if len(batch) >= exportBatchSize {
+					flush()
+				}
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// OTLP/JSON结构，只包含用到的字段
+type otlpTracesData struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 0：未设置 2：错误
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int32:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case uint32:
+		return map[string]interface{}{"intValue": strconv.FormatUint(uint64(v), 10)}
+	case uint64:
+		return map[string]interface{}{"intValue": strconv.FormatUint(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(value)}
+}
+
+func (e *fileExporter) write(writer *bufio.Writer, batch []*Span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		s := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentID,
+			Name:              span.Name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		}
+		for _, attr := range span.Attrs {
+			s.Attributes = append(s.Attributes, otlpKeyValue{Key: attr.Key, Value: otlpValue(attr.Value)})
+		}
+		if span.Err != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Err}
+		}
+		spans = append(spans, s)
+	}
+	data := otlpTracesData{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: otlpValue(e.serviceName)},
+			{Key: "service.instance.id", Value: otlpValue(e.serviceID)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "pp"}, Spans: spans}},
+	}}}
+	line, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(line); err != nil {
+		return err
+	}
+	return writer.WriteByte('\n')
+}
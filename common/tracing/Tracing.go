@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 分布式调用跟踪，traceID在整个调用链中不变，每个处理步骤一个span
+// traceID和spanID的格式和OpenTelemetry一致，分别是32位和16位小写十六进制
+// 使用方法：
+//
+//	ctx, span := tracing.StartSpan(ctx, "enter room")
+//	defer span.End()
+//	span.SetAttr("roomID", roomID)
+
+type spanKey struct{}
+
+// Span 一个处理步骤，End之后导出
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string // 上一个处理步骤，可以在其他服务器
+	Name     string
+	Start    time.Time
+	EndTime  time.Time
+	Attrs    []Attr
+	Err      string // 不为空时表示处理失败
+
+	mutex sync.Mutex
+	ended bool
+}
+
+// Attr span的属性
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// NewTraceID 生成traceID
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// NewSpanID 生成spanID
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		// 随机数失败时用时间生成，只需要保证大概率不重复
+		return fmt.Sprintf("%0*x", n*2, time.Now().UnixNano())[:n*2]
+	}
+	return hex.EncodeToString(buf)
+}
+
+// StartSpan 开始一个处理步骤，ctx中有span时作为子步骤，否则开始新的调用链
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span := &Span{SpanID: NewSpanID(), Name: name, Start: time.Now()}
+	if parent := FromContext(ctx); parent != nil {
+		span.TraceID, span.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		span.TraceID = NewTraceID()
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// ContextWithRemote 使用其他服务器传过来的traceID和spanID作为父步骤，traceID为空时返回原ctx
+func ContextWithRemote(ctx context.Context, traceID, spanID string) context.Context {
+	if traceID == "" {
+		return ctx
+	}
+	// 远端的父步骤只用于关联，不会End和导出
+	return context.WithValue(ctx, spanKey{}, &Span{TraceID: traceID, SpanID: spanID, ended: true})
+}
+
+// FromContext 获取ctx中当前的span，没有时返回nil
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// IDs 获取ctx中的traceID和spanID，没有时为空
+func IDs(ctx context.Context) (traceID, spanID string) {
+	if span := FromContext(ctx); span != nil {
+		return span.TraceID, span.SpanID
+	}
+	return "", ""
+}
+
+// SetAttr 设置属性，End之后设置无效
+func (s *Span) SetAttr(key string, value interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.ended {
+		s.Attrs = append(s.Attrs, Attr{Key: key, Value: value})
+	}
+}
+
+// SetError 标记处理失败
+func (s *Span) SetError(err interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.ended && err != nil {
+		s.Err = fmt.Sprint(err)
+	}
+}
+
+// End 结束处理步骤并导出，重复调用无效
+func (s *Span) End() {
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mutex.Unlock()
+	export(s)
+}
@@ -0,0 +1,130 @@
+// Package watcher 监听文件变化，合并一段时间内的多次变化后统一回调
+// linux下使用inotify，其他系统定时检查文件修改时间
+package watcher
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Watcher 文件监听，监听文件所在目录，编辑器通过重命名替换文件时也能收到
+type Watcher struct {
+	debounce time.Duration              // 最后一次变化后等待多久回调
+	onChange func(files []string)       // 变化回调，files为变化的文件绝对路径
+	mutex    sync.Mutex                 // 保护以下字段
+	dirs     map[string]bool            // 已监听的目录
+	dirFiles map[string]map[string]bool // 目录下需要关心的文件，为nil表示目录下所有文件
+	pending  map[string]bool            // 等待回调的文件
+	timer    *time.Timer                // 防抖计时器
+	closed   bool                       // 是否已关闭
+	backend  *backend                   // 平台相关实现
+}
+
+// NewWatcher 新建文件监听
+func NewWatcher(debounce time.Duration, onChange func(files []string)) (*Watcher, error) {
+	w := &Watcher{
+		debounce: debounce,
+		onChange: onChange,
+		dirs:     make(map[string]bool),
+		pending:  make(map[string]bool),
+		dirFiles: make(map[string]map[string]bool),
+	}
+	b, err := newBackend(w)
+	if err != nil {
+		return nil, err
+	}
+	w.backend = b
+	return w, nil
+}
+
+// AddFile 监听单个文件
+func (w *Watcher) AddFile(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(absPath)
+	w.mutex.Lock()
+	files, ok := w.dirFiles[dir]
+	if !ok {
+		files = make(map[string]bool)
+		w.dirFiles[dir] = files
+	}
+	// 已经监听整个目录时不需要再单独记录
+	if files != nil {
+		files[absPath] = true
+	}
+	w.mutex.Unlock()
+	return w.addDir(dir)
+}
+
+// AddDir 监听目录下的所有文件
+func (w *Watcher) AddDir(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	w.dirFiles[absPath] = nil
+	w.mutex.Unlock()
+	return w.addDir(absPath)
+}
+
+func (w *Watcher) addDir(dir string) error {
+	w.mutex.Lock()
+	if w.dirs[dir] {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.dirs[dir] = true
+	w.mutex.Unlock()
+	return w.backend.add(dir)
+}
+
+// Close 停止监听
+func (w *Watcher) Close() {
+	w.mutex.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mutex.Unlock()
+	w.backend.close()
+}
+
+// notify 收到文件变化，过滤掉不关心的文件后等待防抖时间
+func (w *Watcher) notify(path string) {
+	dir := filepath.Dir(path)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	files, ok := w.dirFiles[dir]
+	if !ok || (files != nil && !files[path]) {
+		return
+	}
+	w.pending[path] = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(w.debounce, w.flush)
+}
+
+func (w *Watcher) flush() {
+	w.mutex.Lock()
+	files := make([]string, 0, len(w.pending))
+	for path := range w.pending {
+		files = append(files, path)
+	}
+	w.pending = make(map[string]bool)
+	closed := w.closed
+	w.mutex.Unlock()
+	if closed || len(files) == 0 {
+		return
+	}
+	sort.Strings(files)
+	w.onChange(files)
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// backend inotify实现
+type backend struct {
+	watcher *Watcher
+	file    *os.File
+	mutex   sync.Mutex
+	wdDirs  map[int]string // watch descriptor对应的目录
+}
+
+func newBackend(w *Watcher) (*backend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	b := &backend{watcher: w, file: os.NewFile(uintptr(fd), "inotify"), wdDirs: make(map[int]string)}
+	go b.readLoop()
+	return b, nil
+}
+
+func (b *backend) add(dir string) error {
+	fd := int(b.file.Fd())
+	wd, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	b.wdDirs[wd] = dir
+	b.mutex.Unlock()
+	return nil
+}
+
+func (b *backend) close() {
+	b.file.Close()
+}
+
+func (b *backend) readLoop() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := b.file.Read(buf)
+		if err != nil {
+			return
+		}
+		offset := 0
+		for offset+syscall.SizeofInotifyEvent <= n {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			name := string(nameBytes)
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			b.mutex.Lock()
+			dir, ok := b.wdDirs[int(event.Wd)]
+			b.mutex.Unlock()
+			if ok && name != "" {
+				b.watcher.notify(filepath.Join(dir, name))
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+//go:build !linux
+
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const pollInterval = time.Second
+
+// backend 定时检查目录下文件修改时间
+type backend struct {
+	watcher *Watcher
+	mutex   sync.Mutex
+	modTime map[string]map[string]time.Time // 目录 -> 文件 -> 修改时间
+	done    chan struct{}
+}
+
+func newBackend(w *Watcher) (*backend, error) {
+	b := &backend{watcher: w, modTime: make(map[string]map[string]time.Time), done: make(chan struct{})}
+	go b.pollLoop()
+	return b, nil
+}
+
+func (b *backend) add(dir string) error {
+	files, err := scanDir(dir)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	b.modTime[dir] = files
+	b.mutex.Unlock()
+	return nil
+}
+
+func (b *backend) close() {
+	close(b.done)
+}
+
+func (b *backend) pollLoop() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.mutex.Lock()
+			for dir, oldFiles := range b.modTime {
+				files, err := scanDir(dir)
+				if err != nil {
+					continue
+				}
+				for path, modTime := range files {
+					if oldTime, ok := oldFiles[path]; !ok || !oldTime.Equal(modTime) {
+						go b.watcher.notify(path)
+					}
+				}
+				b.modTime[dir] = files
+			}
+			b.mutex.Unlock()
+		}
+	}
+}
+
+func scanDir(dir string) (map[string]time.Time, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]time.Time, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files[filepath.Join(dir, entry.Name())] = info.ModTime()
+	}
+	return files, nil
+}
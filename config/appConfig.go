@@ -1,90 +1,301 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"sync"
+	"sync/atomic"
 )
 
 // AppConfig app.json配置读取
+// 每次加载生成一个新的不可变快照，通过原子指针替换，读取时不需要加锁
 type AppConfig struct {
-	appConfig AppConfigInfo
+	path        string // 基础配置文件路径，默认./app.json
+	env         string // 环境名，存在app.<env>.json时覆盖基础配置
+	appConfig   atomic.Pointer[AppConfigInfo]
+	loadMutex   sync.Mutex    // 保护path、env和listeners
+	notifyMutex sync.Mutex    // 保证同一时间只有一次加载，订阅者按加载顺序收到通知
+	listeners   []appListener // 配置变化订阅者
 }
 
-var appConfig *AppConfig
+type appListener struct {
+	name     string
+	listener func(old, new *AppConfigInfo)
+}
+
+var (
+	appConfig     *AppConfig
+	appConfigOnce sync.Once
+)
 
 func NewAppConfig() *AppConfig {
-	if appConfig == nil {
-		appConfig = &AppConfig{}
-	}
+	appConfigOnce.Do(func() {
+		appConfig = &AppConfig{path: defaultAppConfigPath}
+	})
 	return appConfig
 }
 
 type RedisConfig struct {
-	RedisAddr string `json:"addr"`
-	RedisType int    `json:"redistype"`
-	Password  string `json:"password"`
+	RedisAddr string `json:"addr" validate:"required,addr"`
+	RedisType int    `json:"redistype" validate:"redistype"`
+	Password  string `json:"password" secret:"true"`
+}
+
+func (r RedisConfig) String() string {
+	RedactSecret(&r)
+	return fmt.Sprintf("{addr:%s redistype:%d password:%s}", r.RedisAddr, r.RedisType, r.Password)
 }
 
 type StartServerConfig struct {
-	Type      int    `json:"type"`      // 启动的端口类型 1：TCP服务 2：websocket 3：GRPC服务 4：http服务
-	OutAddr   string `json:"outaddr"`   // 对外开放地址
-	InnerAddr string `json:"inneraddr"` // 对内开放地址
+	Type      int    `json:"type" validate:"min=1,max=4"` // 启动的端口类型 1：TCP服务 2：websocket 3：GRPC服务 4：http服务
+	OutAddr   string `json:"outaddr" validate:"addr"`     // 对外开放地址
+	InnerAddr string `json:"inneraddr" validate:"addr"`   // 对内开放地址
 }
 
 type ServersConfig struct {
-	ServerID   int    `json:"serverid"`   // ServerID
-	ServerType int    `json:"servertype"` // 服务类型
-	Addr       string `json:"addr"`       // 连接的Server的IP和端口：ip:port
+	ServerID   int    `json:"serverid" validate:"min=1"`     // ServerID
+	ServerType int    `json:"servertype"`                    // 服务类型
+	Addr       string `json:"addr" validate:"required,addr"` // 连接的Server的IP和端口：ip:port
 }
 
 type MysqlConfig struct {
-	Type     int    `json:"type"`
-	Addr     string `json:"addr"`
-	UserName string `json:"userName"`
-	Pwd      string `json:"pwd"`
-	DbName   string `json:"dbName"`
+	Type     int    `json:"type" validate:"min=1"`
+	Addr     string `json:"addr" validate:"required,addr"`
+	UserName string `json:"userName" validate:"required"`
+	Pwd      string `json:"pwd" secret:"true"`
+	DbName   string `json:"dbName" validate:"required"`
 	Dblog    bool   `json:"dblog"`
 }
 
+func (m MysqlConfig) String() string {
+	RedactSecret(&m)
+	return fmt.Sprintf("{type:%d addr:%s userName:%s pwd:%s dbName:%s dblog:%v}", m.Type, m.Addr, m.UserName, m.Pwd, m.DbName, m.Dblog)
+}
+
+type MaintainConfig struct {
+	Policy   string `json:"policy" default:"reject" validate:"oneof=reject queue"` // 维护期间新进入的处理方式 reject：拒绝(默认) queue：排队等待开服
+	MsgID    uint32 `json:"msgid"`                                                 // 通知玩家维护的消息ID，0表示不通知
+	Message  string `json:"message"`                                               // 通知玩家维护的消息内容
+	QueueMax int    `json:"queuemax" default:"1000" validate:"min=1"`              // 每个玩法最大排队数，默认1000
+}
+
+type LogAsyncConfig struct {
+	Enable     bool   `json:"enable"`                                            // 是否异步写日志
+	BufferSize int    `json:"buffersize" default:"8192" validate:"min=1"`        // 缓冲区最多缓存的日志条数，默认8192，修改后需要关闭再开启才生效
+	Policy     string `json:"policy" default:"drop" validate:"oneof=drop block"` // 缓冲区满时的处理方式 drop：丢弃并计数(默认) block：等待
+}
+
+type LogRotateConfig struct {
+	Policy     string `json:"policy" default:"hourly" validate:"oneof=hourly daily size"` // 日志文件切换方式 hourly：每小时(默认) daily：每天 size：只按logfilemax，都会在超过logfilemax时切换
+	NameFormat string `json:"nameformat" default:"20060102150405"`                        // 日志文件名中的时间格式，Go时间格式
+	Compress   bool   `json:"compress"`                                                   // 切换后是否在后台gzip压缩旧文件
+	MaxAge     int    `json:"maxage" validate:"min=0"`                                    // 旧日志文件保留天数，0表示不删除
+	MaxTotal   int64  `json:"maxtotal" validate:"min=0"`                                  // 旧日志文件最大总大小(字节)，超过时从最旧的开始删除，0表示不限制
+	Symlink    string `json:"symlink"`                                                    // 指向当前日志文件的软链接路径，空表示不创建
+}
+
+type LogSinkConfig struct {
+	Type   string `json:"type" validate:"required,oneof=stdout syslog udp tcp"`    // 输出目标 stdout：标准输出 syslog udp：每行一个udp包 tcp：按行发送
+	Addr   string `json:"addr" validate:"addr"`                                    // udp和tcp的地址，syslog为空时输出到本机syslog，否则用udp发送到该地址
+	Level  int    `json:"level" validate:"min=0,max=5"`                            // 输出的最低日志级别，0表示和全局级别一致，低于全局级别的日志不会输出
+	Format string `json:"format" default:"json" validate:"oneof=text json logfmt"` // 输出格式，默认json
+	Tag    string `json:"tag"`                                                     // syslog的tag，默认为servername
+}
+
+type AdminConfig struct {
+	Enable bool   `json:"enable"`                                        // 是否开启管理接口
+	Addr   string `json:"addr" default:"127.0.0.1:6062" validate:"addr"` // 管理接口监听地址，默认只监听本机，修改后重启生效
+	Token  string `json:"token" secret:"true"`                           // 管理接口的访问令牌，请求带上?token=或者Authorization: Bearer，为空时只允许本机调用，/metrics的GET请求不检查
+}
+
+type TraceConfig struct {
+	Export bool   `json:"export"`                      // 是否导出调用链，不导出时日志中仍然有traceID
+	File   string `json:"file" default:"./trace.json"` // 导出文件，每行一个OTLP/JSON格式的TracesData
+}
+
+type MonitorConfig struct {
+	Interval        int    `json:"interval" default:"5" validate:"min=1"`                // 采样间隔(秒)，默认5
+	MaxGoroutines   int    `json:"maxgoroutines" default:"10000"`                        // 协程数上限，负数表示不检查
+	MaxHeapMB       int    `json:"maxheapmb" default:"2048"`                             // 堆内存上限(MB)，负数表示不检查
+	MaxGCPauseMs    int    `json:"maxgcpausems" default:"100"`                           // 单次GC暂停上限(毫秒)，负数表示不检查
+	MaxQueuePercent int    `json:"maxqueuepercent" default:"80" validate:"max=100"`      // MessageDataChan占用百分比上限，负数表示不检查
+	ProfileDir      string `json:"profiledir" default:"./profile"`                       // 超限时自动保存profile和手动采集的目录
+	ProfileInterval int    `json:"profileinterval" default:"600"`                        // 同一类profile两次保存的最小间隔(秒)，负数表示不保存
+	ProfileKeep     int    `json:"profilekeep" default:"10" validate:"min=1"`            // 每类profile和采集结果最多保留的文件数，超过时删除最旧的
+	History         int    `json:"history" default:"120" validate:"min=1,max=86400"`     // 管理接口保留的采样数
+	CaptureSeconds  int    `json:"captureseconds" default:"30" validate:"min=1,max=600"` // 手动采集cpu和trace的默认时长(秒)
+}
+
+type PProfConfig struct {
+	Enable bool   `json:"enable"`                                        // 是否开启pprof调试接口
+	Addr   string `json:"addr" default:"127.0.0.1:6061" validate:"addr"` // 监听地址，默认只监听本机，修改后重启生效
+	Token  string `json:"token" secret:"true"`                           // 访问令牌，请求带上?token=或者Authorization: Bearer，为空时只允许本机访问
+}
+
+type DelayQueueConfig struct {
+	Enable      bool `json:"enable"`                                            // 是否在本服务器执行延时任务，不执行时也可以添加任务
+	RedisType   int  `json:"redistype" default:"1" validate:"redistype"`        // 保存任务的redis类型
+	Interval    int  `json:"interval" default:"1000" validate:"min=10"`         // 检查到期任务的间隔(毫秒)，默认1000
+	BatchSize   int  `json:"batchsize" default:"100" validate:"min=1,max=1000"` // 每次最多领取的任务数
+	Visibility  int  `json:"visibility" default:"60" validate:"min=1"`          // 任务执行超时(秒)，领取后超时没有确认的任务重新投递
+	MaxAttempts int  `json:"maxattempts" default:"5" validate:"min=1"`          // 最多执行次数，超过后放入死信列表
+	DeadMax     int  `json:"deadmax" default:"10000" validate:"min=1"`          // 死信列表最多保留的任务数
+}
+
+type WatchConfig struct {
+	Enable   bool `json:"enable"`                                  // 是否监听app.json和配置表目录变化自动重新加载
+	Debounce int  `json:"debounce" default:"500" validate:"min=1"` // 最后一次变化后等待多久加载(毫秒)，默认500
+}
+
+// AppConfigInfo app.json配置快照，生成后不能修改
+// default标签为字段零值时的默认值，validate标签为校验规则，见appConfigValidate.go
 type AppConfigInfo struct {
-	ServerID          int                 `json:"serverid"`   // 服务器ID
-	ServerType        int                 `json:"servertype"` // 服务器类型
-	ServerName        string              `json:"servername"` // 服务器名称
-	ServerPort        []StartServerConfig `json:"network"`    // 服务需要开启的端口信息
-	ConnServersConfig []ServersConfig     `json:"servers"`    // 服务器需要连接的服务器信息
-	RedisConfig       []RedisConfig       `json:"redis"`      // 服务器需要连接Redis的配置
-	MysqlConfig       []*MysqlConfig      `json:"mysql"`      // mysql连接配置
-	HttpUrlRoot       string              `json:"url"`        // 请求PHP的根地址
-	Logger            string              `json:"logger"`     // 日志配置路径，包含日志文件名头部
-	LoggerLevel       int                 `json:"level"`      // 日志级别
-	LoggerFileMax     int64               `json:"logfilemax"` // 日志文件最大大小限制
-	BiApiPath         string              `json:"biurl"`      // nginx打点api地址
+	Version           int64               `json:"-"`                                                          // 配置版本，每次加载成功加1
+	ServerID          int                 `json:"serverid" validate:"min=1"`                                  // 服务器ID
+	ServerType        int                 `json:"servertype" validate:"min=1"`                                // 服务器类型
+	ServerName        string              `json:"servername" validate:"required"`                             // 服务器名称
+	ServerPort        []StartServerConfig `json:"network"`                                                    // 服务需要开启的端口信息
+	ConnServersConfig []ServersConfig     `json:"servers" validate:"unique=serverid"`                         // 服务器需要连接的服务器信息
+	RedisConfig       []RedisConfig       `json:"redis"`                                                      // 服务器需要连接Redis的配置
+	MysqlConfig       []*MysqlConfig      `json:"mysql"`                                                      // mysql连接配置
+	HttpUrlRoot       string              `json:"url"`                                                        // 请求PHP的根地址
+	Logger            string              `json:"logger"`                                                     // 日志配置路径，包含日志文件名头部
+	LoggerLevel       int                 `json:"level" default:"2" validate:"min=1,max=5"`                   // 日志级别
+	LogModules        map[string]int      `json:"logmodules"`                                                 // 模块日志级别，key为模块名，未配置的模块使用level
+	LogFormat         string              `json:"logformat" default:"text" validate:"oneof=text json logfmt"` // 日志格式 text：文本(默认) json logfmt
+	LoggerFileMax     int64               `json:"logfilemax" default:"104857600" validate:"min=1"`            // 日志文件最大大小限制
+	LogAsync          LogAsyncConfig      `json:"logasync"`                                                   // 异步日志
+	LogRotate         LogRotateConfig     `json:"logrotate"`                                                  // 日志文件切换和保留
+	LogSinks          []LogSinkConfig     `json:"logsinks"`                                                   // 日志文件之外的输出目标
+	BiApiPath         string              `json:"biurl"`                                                      // nginx打点api地址
+	QuitTimeout       int                 `json:"quittimeout" default:"10" validate:"min=1,max=600"`          // 停服等待网关回复和消息处理完成的超时时间(秒)，默认10秒
+	MaintainConfig    MaintainConfig      `json:"maintain"`                                                   // 维护配置
+	ConfigDir         string              `json:"configdir" default:"./config"`                               // 业务配置表目录，默认./config
+	WatchConfig       WatchConfig         `json:"watch"`                                                      // 配置文件监听
+	AdminConfig       AdminConfig         `json:"admin"`                                                      // 管理接口
+	TraceConfig       TraceConfig         `json:"trace"`                                                      // 调用链跟踪
+	MonitorConfig     MonitorConfig       `json:"monitor"`                                                    // 运行状态监控
+	PProfConfig       PProfConfig         `json:"pprof"`                                                      // pprof调试接口
+	DelayQueueConfig  DelayQueueConfig    `json:"delayqueue"`                                                 // redis延时任务队列
+}
+
+// SetPath 设置配置文件路径和环境名，需要在LoadConfig之前调用
+func (c *AppConfig) SetPath(path, env string) {
+	c.loadMutex.Lock()
+	defer c.loadMutex.Unlock()
+	if path != "" {
+		c.path = path
+	}
+	c.env = env
 }
 
+// LoadConfig 加载基础配置，合并环境配置和PP_开头的环境变量后生成新的快照
+// 订阅者在释放loadMutex后调用，订阅者中可以调用Subscribe、SetPath等，但不能再调用LoadConfig
 func (c *AppConfig) LoadConfig() bool {
-	path := "./app.json"
-	//os.ReadFile
-	data, err := os.ReadFile(path)
+	c.notifyMutex.Lock()
+	defer c.notifyMutex.Unlock()
+	tempConfig, err := c.loadConfigInfo()
 	if err != nil {
-		fmt.Println("load config can not find " + c.ToString())
+		fmt.Println("load config failed "+c.ToString()+" ", err.Error())
 		return false
 	}
-	var tempConfig = AppConfigInfo{}
-	jsonErr := json.Unmarshal(data, &tempConfig)
-	if jsonErr != nil {
-		fmt.Println("config file format error "+c.ToString()+" "+jsonErr.Error(), ",data:", string(data))
+	// 校验不通过时保留旧配置，打印所有错误
+	applyDefault(tempConfig)
+	if errList := tempConfig.Validate(); len(errList) > 0 {
+		fmt.Println("load config failed "+c.ToString()+", validate errors:", len(errList))
+		for _, validateErr := range errList {
+			fmt.Println("  " + validateErr.Error())
+		}
 		return false
 	}
-	c.appConfig = tempConfig
-	fmt.Println("app config load success," + string(data))
+
+	c.loadMutex.Lock()
+	old := c.appConfig.Load()
+	if old != nil {
+		tempConfig.Version = old.Version + 1
+	} else {
+		tempConfig.Version = 1
+	}
+	c.appConfig.Store(tempConfig)
+	listeners := make([]appListener, len(c.listeners))
+	copy(listeners, c.listeners)
+	c.loadMutex.Unlock()
+	fmt.Println("app config load success, version:", tempConfig.Version, ","+tempConfig.RedactedString())
+
+	// 首次加载没有旧配置，不通知订阅者
+	if old != nil {
+		for _, l := range listeners {
+			c.notify(l, old, tempConfig)
+		}
+	}
 	return true
 }
 
+// notify 通知单个订阅者，订阅者panic不影响其他订阅者
+func (c *AppConfig) notify(l appListener, old, new *AppConfigInfo) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("app config listener panic, name:", l.name, ",err:", err)
+		}
+	}()
+	l.listener(old, new)
+}
+
+// Subscribe 订阅配置变化，每次重新加载成功后按订阅顺序调用，old和new都是只读快照
+func (c *AppConfig) Subscribe(name string, listener func(old, new *AppConfigInfo)) {
+	c.loadMutex.Lock()
+	defer c.loadMutex.Unlock()
+	c.listeners = append(c.listeners, appListener{name: name, listener: listener})
+}
+
 func (c *AppConfig) ToString() string {
-	return "app.json"
+	return c.path
 }
 
+// GetConfig 获取当前配置的副本，slice、map和MysqlConfig都是复制的，修改不影响快照
+// 只读取个别字段时使用GetSnapshot，避免复制
 func (a *AppConfig) GetConfig() AppConfigInfo {
-	return a.appConfig
+	snapshot := a.appConfig.Load()
+	if snapshot == nil {
+		return AppConfigInfo{}
+	}
+	return snapshot.clone()
+}
+
+// clone 复制配置，包括slice、map和指针指向的内容
+func (a *AppConfigInfo) clone() AppConfigInfo {
+	info := *a
+	info.ServerPort = append([]StartServerConfig(nil), a.ServerPort...)
+	info.ConnServersConfig = append([]ServersConfig(nil), a.ConnServersConfig...)
+	info.RedisConfig = append([]RedisConfig(nil), a.RedisConfig...)
+	info.LogSinks = append([]LogSinkConfig(nil), a.LogSinks...)
+	if a.MysqlConfig != nil {
+		info.MysqlConfig = make([]*MysqlConfig, len(a.MysqlConfig))
+		for i, mysqlConfig := range a.MysqlConfig {
+			if mysqlConfig != nil {
+				copied := *mysqlConfig
+				info.MysqlConfig[i] = &copied
+			}
+		}
+	}
+	if a.LogModules != nil {
+		info.LogModules = make(map[string]int, len(a.LogModules))
+		for module, level := range a.LogModules {
+			info.LogModules[module] = level
+		}
+	}
+	return info
+}
+
+// GetSnapshot 获取当前配置快照，只读，slice、map和MysqlConfig指向的内容也不能修改，未加载时返回nil
+func (a *AppConfig) GetSnapshot() *AppConfigInfo {
+	return a.appConfig.Load()
+}
+
+// GetVersion 获取当前配置版本，未加载时为0
+func (a *AppConfig) GetVersion() int64 {
+	snapshot := a.appConfig.Load()
+	if snapshot == nil {
+		return 0
+	}
+	return snapshot.Version
 }
@@ -0,0 +1,292 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultAppConfigPath = "./app.json"
+	envPrefix            = "PP_"    // 环境变量覆盖配置的前缀
+	envName              = "PP_ENV" // 环境名的环境变量，命令行没有指定时使用
+	redactedValue        = "******"
+)
+
+// Files 配置由哪些文件合并而来，依次为基础配置和环境配置
+func (c *AppConfig) Files() []string {
+	c.loadMutex.Lock()
+	path, env := c.path, c.env
+	c.loadMutex.Unlock()
+	if env == "" {
+		env = os.Getenv(envName)
+	}
+	files := []string{path}
+	if env != "" {
+		ext := filepath.Ext(path)
+		files = append(files, strings.TrimSuffix(path, ext)+"."+env+ext)
+	}
+	return files
+}
+
+// loadConfigInfo 读取基础配置和环境配置，按层合并后应用环境变量覆盖
+func (c *AppConfig) loadConfigInfo() (*AppConfigInfo, error) {
+	c.loadMutex.Lock()
+	path, env := c.path, c.env
+	c.loadMutex.Unlock()
+	if env == "" {
+		env = os.Getenv(envName)
+	}
+
+	merged, err := readJsonMap(path)
+	if err != nil {
+		return nil, err
+	}
+	if env != "" {
+		envPath := c.Files()[1]
+		if _, statErr := os.Stat(envPath); statErr == nil {
+			override, err := readJsonMap(envPath)
+			if err != nil {
+				return nil, err
+			}
+			merged = mergeJsonMap(merged, override)
+		}
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	tempConfig := &AppConfigInfo{}
+	if err := json.Unmarshal(data, tempConfig); err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverride(tempConfig, os.Environ()); err != nil {
+		return nil, err
+	}
+	if err := decryptSecrets(tempConfig); err != nil {
+		return nil, err
+	}
+	return tempConfig, nil
+}
+
+func readJsonMap(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("%s format error: %v", path, err)
+	}
+	return result, nil
+}
+
+// mergeJsonMap 把override合并到base，对象递归合并，数组和其他值整体替换
+func mergeJsonMap(base, override map[string]interface{}) map[string]interface{} {
+	for key, value := range override {
+		baseMap, baseOk := base[key].(map[string]interface{})
+		overrideMap, overrideOk := value.(map[string]interface{})
+		if baseOk && overrideOk {
+			base[key] = mergeJsonMap(baseMap, overrideMap)
+		} else {
+			base[key] = value
+		}
+	}
+	return base
+}
+
+// applyEnvOverride 用PP_开头的环境变量覆盖配置，变量名为json字段名路径的大写，数组用下标，map用小写的key
+// 例如 PP_SERVERID=3 PP_REDIS_0_PASSWORD=xxx PP_MAINTAIN_MESSAGE=xxx PP_LOGMODULES_CONN=1
+func applyEnvOverride(info *AppConfigInfo, environ []string) error {
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || pair[0] == envName || pair[0] == secretKeyEnv || pair[0] == secretKeyFile {
+			continue
+		}
+		segments := strings.Split(strings.ToLower(strings.TrimPrefix(pair[0], envPrefix)), "_")
+		found, err := setByPath(reflect.ValueOf(info).Elem(), segments, pair[1])
+		if err != nil {
+			return fmt.Errorf("env %s: %v", pair[0], err)
+		}
+		if found {
+			fmt.Println("app config override by env " + pair[0])
+		}
+	}
+	return nil
+}
+
+// setByPath 按路径设置字段，路径不存在时返回false
+func setByPath(value reflect.Value, segments []string, raw string) (bool, error) {
+	if len(segments) == 0 {
+		return true, setValue(value, raw)
+	}
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		return setByPath(value.Elem(), segments, raw)
+	case reflect.Struct:
+		// 字段名可能包含下划线，从长到短匹配
+		for n := len(segments); n > 0; n-- {
+			name := strings.Join(segments[:n], "_")
+			for i := 0; i < value.NumField(); i++ {
+				field := value.Type().Field(i)
+				tag := strings.Split(field.Tag.Get("json"), ",")[0]
+				if !field.IsExported() || tag == "-" || !strings.EqualFold(tag, name) {
+					continue
+				}
+				return setByPath(value.Field(i), segments[n:], raw)
+			}
+		}
+	case reflect.Slice:
+		index, err := strconv.Atoi(segments[0])
+		if err != nil || index < 0 || index > value.Len() {
+			return false, nil
+		}
+		// 下标等于长度时追加一个元素
+		if index == value.Len() {
+			value.Set(reflect.Append(value, reflect.Zero(value.Type().Elem())))
+		}
+		return setByPath(value.Index(index), segments[1:], raw)
+	case reflect.Map:
+		// 剩余的路径都是key，key中可以有下划线，map的值只能整体设置
+		if value.Type().Key().Kind() != reflect.String {
+			return false, fmt.Errorf("map key must be string, got %s", value.Type().Key())
+		}
+		if value.IsNil() {
+			value.Set(reflect.MakeMap(value.Type()))
+		}
+		key := reflect.ValueOf(strings.Join(segments, "_")).Convert(value.Type().Key())
+		elem := reflect.New(value.Type().Elem()).Elem()
+		if err := setValue(elem, raw); err != nil {
+			return true, err
+		}
+		value.SetMapIndex(key, elem)
+		return true, nil
+	}
+	return false, nil
+}
+
+func setValue(value reflect.Value, raw string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(v)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(v)
+	default:
+		return json.Unmarshal([]byte(raw), value.Addr().Interface())
+	}
+	return nil
+}
+
+// Redacted 返回隐藏了secret字段的配置副本，用于打印
+func (a *AppConfigInfo) Redacted() *AppConfigInfo {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return &AppConfigInfo{}
+	}
+	redacted := &AppConfigInfo{}
+	_ = json.Unmarshal(data, redacted)
+	redacted.Version = a.Version
+	RedactSecret(redacted)
+	return redacted
+}
+
+// RedactedString 隐藏secret字段后的json
+func (a *AppConfigInfo) RedactedString() string {
+	data, err := json.Marshal(a.Redacted())
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// DiffConfig 对比两个配置，返回变化的字段，格式为 path: old -> new，secret字段已隐藏
+func DiffConfig(old, new *AppConfigInfo) []string {
+	oldFlat := make(map[string]string)
+	newFlat := make(map[string]string)
+	flattenConfig(old.Redacted(), oldFlat)
+	flattenConfig(new.Redacted(), newFlat)
+
+	diff := make([]string, 0)
+	for key, oldValue := range oldFlat {
+		newValue, ok := newFlat[key]
+		if !ok {
+			diff = append(diff, key+": "+oldValue+" -> (removed)")
+		} else if newValue != oldValue {
+			diff = append(diff, key+": "+oldValue+" -> "+newValue)
+		}
+	}
+	for key, newValue := range newFlat {
+		if _, ok := oldFlat[key]; !ok {
+			diff = append(diff, key+": (added) -> "+newValue)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// flattenConfig 把配置展开为 字段路径 -> 值
+func flattenConfig(info *AppConfigInfo, result map[string]string) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return
+	}
+	flattenValue("", tree, result)
+}
+
+func flattenValue(path string, value interface{}, result map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			flattenValue(childPath, child, result)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenValue(fmt.Sprintf("%s[%d]", path, i), child, result)
+		}
+	default:
+		data, _ := json.Marshal(v)
+		result[path] = string(data)
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnvOverride(t *testing.T) {
+	info := &AppConfigInfo{
+		ServerID:    1,
+		RedisConfig: []RedisConfig{{RedisAddr: "127.0.0.1:6379", RedisType: 1}},
+		MysqlConfig: []*MysqlConfig{{Type: 1, Addr: "127.0.0.1:3306"}},
+		LogModules:  map[string]int{"conn": 2},
+	}
+	environ := []string{
+		"PATH=/usr/bin",
+		"PP_ENV=test",
+		"PP_SERVERID=3",
+		"PP_SERVERNAME=room=1",
+		"PP_REDIS_0_PASSWORD=secret",
+		"PP_REDIS_1_ADDR=127.0.0.1:6380",
+		"PP_MYSQL_0_DBNAME=game",
+		"PP_MAINTAIN_MESSAGE=维护中",
+		"PP_LOGROTATE_MAXTOTAL=1024",
+		"PP_WATCH_ENABLE=true",
+		"PP_LOGMODULES_CONN=1",
+		"PP_LOGMODULES_DELAY_QUEUE=4",
+		"PP_NOTEXIST=1",
+		"PP_REDIS_5_ADDR=127.0.0.1:6381",
+	}
+	if err := applyEnvOverride(info, environ); err != nil {
+		t.Fatalf("applyEnvOverride failed: %v", err)
+	}
+
+	if info.ServerID != 3 || info.ServerName != "room=1" {
+		t.Fatalf("serverid = %d, servername = %q", info.ServerID, info.ServerName)
+	}
+	wantRedis := []RedisConfig{{RedisAddr: "127.0.0.1:6379", RedisType: 1, Password: "secret"}, {RedisAddr: "127.0.0.1:6380"}}
+	if !reflect.DeepEqual(info.RedisConfig, wantRedis) {
+		t.Fatalf("redis = %+v, want %+v", info.RedisConfig, wantRedis)
+	}
+	if info.MysqlConfig[0].DbName != "game" || info.MysqlConfig[0].Addr != "127.0.0.1:3306" {
+		t.Fatalf("mysql = %+v", *info.MysqlConfig[0])
+	}
+	if info.MaintainConfig.Message != "维护中" || info.LogRotate.MaxTotal != 1024 || !info.WatchConfig.Enable {
+		t.Fatalf("maintain = %+v, logrotate = %+v, watch = %+v", info.MaintainConfig, info.LogRotate, info.WatchConfig)
+	}
+	wantModules := map[string]int{"conn": 1, "delay_queue": 4}
+	if !reflect.DeepEqual(info.LogModules, wantModules) {
+		t.Fatalf("logmodules = %v, want %v", info.LogModules, wantModules)
+	}
+}
+
+func TestApplyEnvOverrideNilMap(t *testing.T) {
+	info := &AppConfigInfo{}
+	if err := applyEnvOverride(info, []string{"PP_LOGMODULES_TIMER=5"}); err != nil {
+		t.Fatalf("applyEnvOverride failed: %v", err)
+	}
+	if info.LogModules["timer"] != 5 {
+		t.Fatalf("logmodules = %v", info.LogModules)
+	}
+}
+
+func TestApplyEnvOverrideError(t *testing.T) {
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"PP_SERVERID=abc", "env PP_SERVERID"},
+		{"PP_WATCH_ENABLE=yes", "env PP_WATCH_ENABLE"},
+		{"PP_LOGMODULES_CONN=debug", "env PP_LOGMODULES_CONN"},
+		{"PP_NETWORK=[1", "env PP_NETWORK"},
+	}
+	for _, tt := range tests {
+		err := applyEnvOverride(&AppConfigInfo{}, []string{tt.env})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want contains %q", tt.env, err, tt.want)
+		}
+	}
+}
+
+func TestMergeJsonMap(t *testing.T) {
+	var base, override map[string]interface{}
+	_ = json.Unmarshal([]byte(`{"serverid":1,"maintain":{"policy":"queue","msgid":100},"redis":[{"addr":"a"},{"addr":"b"}]}`), &base)
+	_ = json.Unmarshal([]byte(`{"serverid":2,"maintain":{"msgid":200},"redis":[{"addr":"c"}]}`), &override)
+	merged := mergeJsonMap(base, override)
+
+	data, _ := json.Marshal(merged)
+	want := `{"maintain":{"msgid":200,"policy":"queue"},"redis":[{"addr":"c"}],"serverid":2}`
+	if string(data) != want {
+		t.Fatalf("merged = %s, want %s", data, want)
+	}
+}
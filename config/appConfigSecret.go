@@ -0,0 +1,172 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// 加密的配置值格式为 ENC(base64(nonce+密文))，使用AES-256-GCM
+// 密钥为32字节，base64编码后放在环境变量PP_CONFIG_KEY中，或者放在PP_CONFIG_KEY_FILE指向的文件中
+const (
+	secretPrefix  = "ENC("
+	secretSuffix  = ")"
+	secretKeyEnv  = "PP_CONFIG_KEY"
+	secretKeyFile = "PP_CONFIG_KEY_FILE"
+)
+
+// loadSecretKey 读取解密密钥
+func loadSecretKey() ([]byte, error) {
+	encoded := os.Getenv(secretKeyEnv)
+	if encoded == "" {
+		path := os.Getenv(secretKeyFile)
+		if path == "" {
+			return nil, fmt.Errorf("secret key not found, set %s or %s", secretKeyEnv, secretKeyFile)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("secret key must be base64: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// IsEncryptedSecret 是否是加密的配置值
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix) && strings.HasSuffix(value, secretSuffix)
+}
+
+// EncryptSecret 加密配置值，返回可以直接写到app.json中的ENC(...)
+func EncryptSecret(plain string) (string, error) {
+	key, err := loadSecretKey()
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed) + secretSuffix, nil
+}
+
+func decryptSecret(key []byte, value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(value, secretPrefix), secretSuffix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptSecrets 解密配置中标记了secret:"true"的ENC(...)字段，没有加密字段时不需要密钥
+func decryptSecrets(info *AppConfigInfo) error {
+	var key []byte
+	var errList []string
+	walkSecret(reflect.ValueOf(info), "", func(field reflect.Value, path string) {
+		if !IsEncryptedSecret(field.String()) {
+			return
+		}
+		if key == nil {
+			var err error
+			if key, err = loadSecretKey(); err != nil {
+				errList = append(errList, err.Error())
+				key = []byte{}
+			}
+		}
+		if len(key) == 0 {
+			return
+		}
+		plain, err := decryptSecret(key, field.String())
+		if err != nil {
+			errList = append(errList, path+": decrypt failed")
+			return
+		}
+		field.SetString(plain)
+	})
+	if len(errList) > 0 {
+		return errors.New(strings.Join(errList, "; "))
+	}
+	return nil
+}
+
+// RedactSecret 把结构体中标记了secret:"true"的非空字符串字段替换为******，v必须是指针
+func RedactSecret(v interface{}) {
+	walkSecret(reflect.ValueOf(v), "", func(field reflect.Value, path string) {
+		if field.String() != "" {
+			field.SetString(redactedValue)
+		}
+	})
+}
+
+// walkSecret 遍历所有标记了secret:"true"的字符串字段
+func walkSecret(value reflect.Value, path string, fn func(field reflect.Value, path string)) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !value.IsNil() {
+			walkSecret(value.Elem(), path, fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := strings.Split(field.Tag.Get("json"), ",")[0]
+			if fieldPath == "" || fieldPath == "-" {
+				fieldPath = field.Name
+			}
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+			if field.Tag.Get("secret") == "true" && value.Field(i).Kind() == reflect.String {
+				if value.Field(i).CanSet() {
+					fn(value.Field(i), fieldPath)
+				}
+				continue
+			}
+			walkSecret(value.Field(i), fieldPath, fn)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			walkSecret(value.Index(i), fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	}
+}
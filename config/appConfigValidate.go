@@ -0,0 +1,204 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 校验规则，写在validate标签中，多个规则用逗号分隔
+//
+//	required      字段不能为零值
+//	min=N max=N   数字的取值范围，字符串和数组的长度范围
+//	addr          ip:port格式，空字符串不校验
+//	oneof=a b     取值只能是其中之一，空字符串不校验
+//	unique=key    数组中元素的key字段不能重复
+//	redistype     必须是已注册的redis类型，见RegisterRedisType
+
+var (
+	redisTypes     = make(map[int]bool) // 已注册的redis类型
+	redisTypeMutex sync.RWMutex
+)
+
+// RegisterRedisType 注册业务使用的redis类型，app.json中出现未注册的类型时校验失败
+func RegisterRedisType(redisType int) {
+	redisTypeMutex.Lock()
+	defer redisTypeMutex.Unlock()
+	redisTypes[redisType] = true
+}
+
+func isKnownRedisType(redisType int) bool {
+	redisTypeMutex.RLock()
+	defer redisTypeMutex.RUnlock()
+	// 没有注册任何类型时不校验
+	return len(redisTypes) == 0 || redisTypes[redisType]
+}
+
+// Validate 按validate标签校验配置，返回所有错误
+func (a *AppConfigInfo) Validate() []error {
+	errList := make([]error, 0)
+	validateValue(reflect.ValueOf(a).Elem(), "", &errList)
+	return errList
+}
+
+// applyDefault 按default标签给零值字段设置默认值
+func applyDefault(a *AppConfigInfo) {
+	defaultValue(reflect.ValueOf(a).Elem())
+}
+
+func defaultValue(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			defaultValue(value.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if tag, ok := field.Tag.Lookup("default"); ok && value.Field(i).IsZero() {
+				if err := setValue(value.Field(i), tag); err != nil {
+					fmt.Println("app config default value error, field:", field.Name, ",err:", err)
+				}
+				continue
+			}
+			defaultValue(value.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			defaultValue(value.Index(i))
+		}
+	}
+}
+
+func validateValue(value reflect.Value, path string, errList *[]error) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			validateValue(value.Elem(), path, errList)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			if tag := field.Tag.Get("validate"); tag != "" {
+				for _, rule := range strings.Split(tag, ",") {
+					if err := validateRule(value.Field(i), rule); err != nil {
+						*errList = append(*errList, fmt.Errorf("%s: %v", fieldPath, err))
+					}
+				}
+			}
+			validateValue(value.Field(i), fieldPath, errList)
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			validateValue(value.Index(i), fmt.Sprintf("%s[%d]", path, i), errList)
+		}
+	}
+}
+
+func validateRule(value reflect.Value, rule string) error {
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		if value.IsZero() {
+			return fmt.Errorf("is required")
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("bad rule %s", rule)
+		}
+		var number float64
+		switch value.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			number = float64(value.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			number = float64(value.Uint())
+		case reflect.Float32, reflect.Float64:
+			number = value.Float()
+		case reflect.String, reflect.Slice, reflect.Map:
+			number = float64(value.Len())
+		}
+		if name == "min" && number < limit {
+			return fmt.Errorf("must be >= %s, got %v", arg, number)
+		}
+		if name == "max" && number > limit {
+			return fmt.Errorf("must be <= %s, got %v", arg, number)
+		}
+	case "addr":
+		addr := value.String()
+		if addr == "" {
+			return nil
+		}
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid address %q", addr)
+		}
+		portNum, err := strconv.Atoi(port)
+		if err != nil || portNum <= 0 || portNum > 65535 {
+			return fmt.Errorf("invalid port in address %q", addr)
+		}
+	case "oneof":
+		str := fmt.Sprint(value.Interface())
+		if str == "" {
+			return nil
+		}
+		for _, option := range strings.Fields(arg) {
+			if option == str {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of [%s], got %q", arg, str)
+	case "unique":
+		seen := make(map[interface{}]int)
+		for i := 0; i < value.Len(); i++ {
+			key, ok := jsonField(value.Index(i), arg)
+			if !ok {
+				return fmt.Errorf("bad rule %s", rule)
+			}
+			if first, exist := seen[key]; exist {
+				return fmt.Errorf("duplicate %s %v at [%d] and [%d]", arg, key, first, i)
+			}
+			seen[key] = i
+		}
+	case "redistype":
+		if !isKnownRedisType(int(value.Int())) {
+			return fmt.Errorf("unknown redis type %d", value.Int())
+		}
+	default:
+		return fmt.Errorf("unknown rule %s", rule)
+	}
+	return nil
+}
+
+// jsonField 按json字段名获取结构体字段的值
+func jsonField(value reflect.Value, name string) (interface{}, bool) {
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, false
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, false
+	}
+	for i := 0; i < value.NumField(); i++ {
+		if strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0] == name {
+			return value.Field(i).Interface(), true
+		}
+	}
+	return nil, false
+}
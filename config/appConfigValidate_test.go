@@ -0,0 +1,99 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func newValidConfig() *AppConfigInfo {
+	info := &AppConfigInfo{
+		ServerID:          1,
+		ServerType:        4,
+		ServerName:        "game",
+		ServerPort:        []StartServerConfig{{Type: 1, InnerAddr: "127.0.0.1:8001"}},
+		ConnServersConfig: []ServersConfig{{ServerID: 1, Addr: "127.0.0.1:9001"}, {ServerID: 2, Addr: "127.0.0.1:9002"}},
+		RedisConfig:       []RedisConfig{{RedisAddr: "127.0.0.1:6379", RedisType: 1}},
+		MysqlConfig:       []*MysqlConfig{{Type: 1, Addr: "127.0.0.1:3306", UserName: "root", DbName: "game"}},
+	}
+	applyDefault(info)
+	return info
+}
+
+func TestApplyDefault(t *testing.T) {
+	info := newValidConfig()
+	if info.LoggerLevel != 2 || info.LogFormat != "text" || info.QuitTimeout != 10 || info.ConfigDir != "./config" {
+		t.Fatalf("level = %d, logformat = %q, quittimeout = %d, configdir = %q", info.LoggerLevel, info.LogFormat, info.QuitTimeout, info.ConfigDir)
+	}
+	if info.MaintainConfig.Policy != "reject" || info.WatchConfig.Debounce != 500 || info.AdminConfig.Addr != "127.0.0.1:6062" {
+		t.Fatalf("maintain = %+v, watch = %+v, admin = %+v", info.MaintainConfig, info.WatchConfig, info.AdminConfig)
+	}
+
+	// 已经配置的字段不覆盖，数组中的元素也设置默认值
+	info = &AppConfigInfo{LoggerLevel: 4, LogSinks: []LogSinkConfig{{Type: "stdout"}, {Type: "tcp", Format: "text"}}}
+	applyDefault(info)
+	if info.LoggerLevel != 4 || info.LogSinks[0].Format != "json" || info.LogSinks[1].Format != "text" {
+		t.Fatalf("level = %d, logsinks = %+v", info.LoggerLevel, info.LogSinks)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if errList := newValidConfig().Validate(); len(errList) != 0 {
+		t.Fatalf("valid config got errors: %v", errList)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(info *AppConfigInfo)
+		want   string
+	}{
+		{"required", func(info *AppConfigInfo) { info.ServerName = "" }, "servername: is required"},
+		{"min", func(info *AppConfigInfo) { info.ServerID = 0 }, "serverid: must be >= 1, got 0"},
+		{"max", func(info *AppConfigInfo) { info.LoggerLevel = 6 }, "level: must be <= 5, got 6"},
+		{"oneof", func(info *AppConfigInfo) { info.LogFormat = "xml" }, `logformat: must be one of [text json logfmt], got "xml"`},
+		{"addr", func(info *AppConfigInfo) { info.RedisConfig[0].RedisAddr = "127.0.0.1" }, `redis[0].addr: invalid address "127.0.0.1"`},
+		{"addr port", func(info *AppConfigInfo) { info.ServerPort[0].InnerAddr = "127.0.0.1:70000" }, `network[0].inneraddr: invalid port`},
+		{"pointer element", func(info *AppConfigInfo) { info.MysqlConfig[0].DbName = "" }, "mysql[0].dbName: is required"},
+		{"unique", func(info *AppConfigInfo) { info.ConnServersConfig[1].ServerID = 1 }, "servers: duplicate serverid 1 at [0] and [1]"},
+		{"nested", func(info *AppConfigInfo) { info.DelayQueueConfig.BatchSize = 1001 }, "delayqueue.batchsize: must be <= 1000, got 1001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := newValidConfig()
+			tt.modify(info)
+			errList := info.Validate()
+			if len(errList) != 1 || !strings.Contains(errList[0].Error(), tt.want) {
+				t.Fatalf("errors = %v, want one error contains %q", errList, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAllErrors(t *testing.T) {
+	info := newValidConfig()
+	info.ServerID = 0
+	info.ServerName = ""
+	info.LogRotate.Policy = "weekly"
+	if errList := info.Validate(); len(errList) != 3 {
+		t.Fatalf("errors = %v, want 3 errors", errList)
+	}
+}
+
+func TestValidateRedisType(t *testing.T) {
+	defer func() {
+		redisTypeMutex.Lock()
+		redisTypes = make(map[int]bool)
+		redisTypeMutex.Unlock()
+	}()
+	info := newValidConfig()
+	info.RedisConfig = append(info.RedisConfig, RedisConfig{RedisAddr: "127.0.0.1:6380", RedisType: 2})
+	// 没有注册任何类型时不校验
+	if errList := info.Validate(); len(errList) != 0 {
+		t.Fatalf("errors = %v", errList)
+	}
+
+	RegisterRedisType(1)
+	errList := info.Validate()
+	if len(errList) != 1 || errList[0].Error() != "redis[1].redistype: unknown redis type 2" {
+		t.Fatalf("errors = %v", errList)
+	}
+}
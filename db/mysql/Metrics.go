@@ -0,0 +1,32 @@
+package mysql
+
+import (
+	"database/sql"
+	"pp/common/metrics"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+func init() {
+	dbStat := func(name, help, typ string, value func(stats sql.DBStats) float64) {
+		metrics.NewCollector(name, help, typ, []string{"type"}, func(emit func(float64, ...string)) {
+			if client == nil {
+				return
+			}
+			client.Range(func(dbType DbType, db *gorm.DB) {
+				if sqlDB, err := db.DB(); err == nil {
+					emit(value(sqlDB.Stats()), strconv.Itoa(int(dbType)))
+				}
+			})
+		})
+	}
+	dbStat("pp_mysql_open_conns", "打开的连接数", metrics.TypeGauge, func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	dbStat("pp_mysql_in_use_conns", "使用中的连接数", metrics.TypeGauge, func(s sql.DBStats) float64 { return float64(s.InUse) })
+	dbStat("pp_mysql_idle_conns", "空闲连接数", metrics.TypeGauge, func(s sql.DBStats) float64 { return float64(s.Idle) })
+	dbStat("pp_mysql_max_open_conns", "最大连接数", metrics.TypeGauge, func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	dbStat("pp_mysql_wait_count_total", "等待连接的次数", metrics.TypeCounter, func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	dbStat("pp_mysql_wait_seconds_total", "等待连接的总时间(秒)", metrics.TypeCounter, func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	dbStat("pp_mysql_max_idle_closed_total", "超过最大空闲数关闭的连接数", metrics.TypeCounter, func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	dbStat("pp_mysql_max_lifetime_closed_total", "超过最大生存时间关闭的连接数", metrics.TypeCounter, func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
@@ -5,7 +5,6 @@ import (
 	"pp/log"
 	"sync"
 
-	glog "gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 
 	"gorm.io/gorm"
@@ -25,7 +24,7 @@ const (
 var (
 	client            *DbMgr
 	clientOnce        sync.Once
-	logger            = log.GetLogger()
+	logger            = log.GetLogger().Module("mysql")
 	ErrRecordNotFound = gorm.ErrRecordNotFound
 )
 
@@ -59,6 +58,17 @@ func (d *DbMgr) Db(Type DbType) (*gorm.DB, bool) {
 	return db, ok
 }
 
+// Range 遍历所有数据库连接
+func (d *DbMgr) Range(fn func(Type DbType, db *gorm.DB)) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	for dbType, db := range d.clients {
+		if db != nil {
+			fn(dbType, db)
+		}
+	}
+}
+
 // SetDb 添加redisClient
 func (d *DbMgr) SetDb(Type DbType, db *gorm.DB) {
 	d.mutex.Lock()
@@ -66,8 +76,8 @@ func (d *DbMgr) SetDb(Type DbType, db *gorm.DB) {
 	d.clients[Type] = db
 }
 
-// NewDB 初始化mysql
-func NewDB(addr, userName, pwd, dbName string, opts ...Option) *gorm.DB {
+// NewDB 初始化mysql，连接失败时返回错误
+func NewDB(addr, userName, pwd, dbName string, opts ...Option) (*gorm.DB, error) {
 	options := options{
 		dblog: false,
 	}
@@ -80,18 +90,17 @@ func NewDB(addr, userName, pwd, dbName string, opts ...Option) *gorm.DB {
 			TablePrefix:   "victory_", // 表名前缀
 			SingularTable: true,       // 关闭复数表明
 		},
-		SkipDefaultTransaction: true, // 禁用默认事务
+		SkipDefaultTransaction: true,                         // 禁用默认事务
+		Logger:                 newGormLogger(options.dblog), // sql日志写到mysql模块日志
 	})
-	if !options.dblog {
-		db.Logger = glog.Default.LogMode(glog.Silent)
-	} else {
-		db.Logger = glog.Default.LogMode(glog.Info)
+	if err != nil {
+		logger.Error("failed opening connection to mysql, addr:", addr, ",dbName:", dbName, ",err:", err)
+		return nil, fmt.Errorf("open mysql %s/%s: %w", addr, dbName, err)
 	}
+	sqlDB, err := db.DB()
 	if err != nil {
-		fmt.Println("failed opening connection to mysql: ", err)
-		logger.Fatal("failed opening connection to mysql: %v", err)
+		return nil, fmt.Errorf("open mysql %s/%s: %w", addr, dbName, err)
 	}
-	sqlDB, _ := db.DB()
 	if options.maxIdleConn > 0 {
 		sqlDB.SetMaxIdleConns(options.maxIdleConn)
 	}
@@ -101,7 +110,7 @@ func NewDB(addr, userName, pwd, dbName string, opts ...Option) *gorm.DB {
 	if options.maxOpenConn > 0 {
 		sqlDB.SetMaxOpenConns(options.maxOpenConn)
 	}
-	return db
+	return db, nil
 }
 
 // generateDBUrl 生成mysql的链接地址
@@ -0,0 +1,83 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pp/common/tracing"
+	"pp/log"
+	"time"
+
+	"gorm.io/gorm"
+	glog "gorm.io/gorm/logger"
+)
+
+// gormLogger gorm的sql日志写到mysql模块日志中，带有ctx中的traceID
+// 使用db.WithContext(ctx)时sql会作为子步骤记录到调用链中
+// 开启dblog时sql日志为Info级别，否则为Debug级别
+type gormLogger struct {
+	level glog.LogLevel
+}
+
+func newGormLogger(dblog bool) glog.Interface {
+	if dblog {
+		return &gormLogger{level: glog.Info}
+	}
+	return &gormLogger{level: glog.Silent}
+}
+
+func (l *gormLogger) LogMode(level glog.LogLevel) glog.Interface {
+	return &gormLogger{level: level}
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	logger.Ctx(ctx).Info(fmt.Sprintf(msg, data...))
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	logger.Ctx(ctx).Warn(fmt.Sprintf(msg, data...))
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	logger.Ctx(ctx).Error(fmt.Sprintf(msg, data...))
+}
+
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	level := log.LogDebug
+	switch {
+	case err != nil && l.level >= glog.Error:
+		level = log.LogError
+	case l.level >= glog.Info:
+		level = log.LogInfo
+	}
+	// 没有调用跟踪并且日志不输出时不生成sql
+	entry := logger.Ctx(ctx)
+	traced := tracing.FromContext(ctx) != nil
+	if !traced && !entry.Enabled(level) {
+		return
+	}
+	sql, rows := fc()
+	duration := time.Since(begin)
+	if traced {
+		_, span := tracing.StartSpan(ctx, "mysql")
+		span.Start = begin
+		span.SetAttr("db.system", "mysql")
+		span.SetAttr("db.statement", sql)
+		span.SetAttr("db.rows_affected", rows)
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+	}
+	switch level {
+	case log.LogError:
+		entry.Error("mysql call", "sql", sql, "rows", rows, "duration", duration.Microseconds(), "err", err)
+	case log.LogInfo:
+		entry.Info("mysql call", "sql", sql, "rows", rows, "duration", duration.Microseconds(), "err", err)
+	default:
+		entry.Debug("mysql call", "sql", sql, "rows", rows, "duration", duration.Microseconds(), "err", err)
+	}
+}
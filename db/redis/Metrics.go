@@ -0,0 +1,29 @@
+package redis
+
+import (
+	"pp/common/metrics"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func init() {
+	poolStat := func(name, help, typ string, value func(stats *redis.PoolStats) uint32) {
+		metrics.NewCollector(name, help, typ, []string{"redistype", "addr"}, func(emit func(float64, ...string)) {
+			if redisMgr == nil {
+				return
+			}
+			redisMgr.Range(func(redisType int, client *RedisClient) {
+				if client.rdb != nil {
+					emit(float64(value(client.PoolStats())), strconv.Itoa(redisType), client.ConnString)
+				}
+			})
+		})
+	}
+	poolStat("pp_redis_pool_hits_total", "连接池命中次数", metrics.TypeCounter, func(s *redis.PoolStats) uint32 { return s.Hits })
+	poolStat("pp_redis_pool_misses_total", "连接池未命中次数", metrics.TypeCounter, func(s *redis.PoolStats) uint32 { return s.Misses })
+	poolStat("pp_redis_pool_timeouts_total", "获取连接超时次数", metrics.TypeCounter, func(s *redis.PoolStats) uint32 { return s.Timeouts })
+	poolStat("pp_redis_pool_total_conns", "连接池连接数", metrics.TypeGauge, func(s *redis.PoolStats) uint32 { return s.TotalConns })
+	poolStat("pp_redis_pool_idle_conns", "连接池空闲连接数", metrics.TypeGauge, func(s *redis.PoolStats) uint32 { return s.IdleConns })
+	poolStat("pp_redis_pool_stale_conns_total", "连接池关闭的失效连接数", metrics.TypeCounter, func(s *redis.PoolStats) uint32 { return s.StaleConns })
+}
@@ -2,10 +2,13 @@ package redis
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"pp/config"
 	"pp/log"
 	"runtime"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -13,9 +16,13 @@ import (
 
 const RedisTypePlayer = 1 // 玩家缓存数据
 
+func init() {
+	config.RegisterRedisType(RedisTypePlayer)
+}
+
 var (
 	redisMgr *RedisClientMgr
-	logger   = log.GetLogger()
+	logger   = log.GetLogger().Module("redis")
 	Nil      = redis.Nil
 )
 
@@ -25,6 +32,7 @@ var (
 // 			testMgr.GetRedisClientByType(1) or testMgr.GetRedisClient()
 
 type RedisClientMgr struct {
+	mutex          sync.RWMutex           // 保护redisClientMap，配置变化时会在运行中添加连接
 	redisClientMap map[int][]*RedisClient // map的key是redis的类型， value是RedisClient对象
 }
 
@@ -38,12 +46,16 @@ func GetInstance() *RedisClientMgr {
 
 // AddRedisClientByType 根据redisType添加一个RedisClient
 func (clientMgr *RedisClientMgr) AddRedisClientByType(redisType int, client *RedisClient) {
+	clientMgr.mutex.Lock()
+	defer clientMgr.mutex.Unlock()
 	clientMgr.redisClientMap[redisType] = append(clientMgr.redisClientMap[redisType], client)
 }
 
 // GetRedisClientByType 根据redisType随机获取一个RedisClient
 func (clientMgr *RedisClientMgr) GetRedisClientByType(redisType int) (pClient *RedisClient, index int) {
+	clientMgr.mutex.RLock()
 	redisClients, ok := clientMgr.redisClientMap[redisType]
+	clientMgr.mutex.RUnlock()
 	if !ok {
 		logger.Error("Get redis client error,redisType:", redisType)
 		return nil, 0
@@ -62,14 +74,33 @@ func (clientMgr *RedisClientMgr) GetRedisClientByType(redisType int) (pClient *R
 	return pClient, randIndex + 1
 }
 
+// Range 遍历所有RedisClient，遍历的是调用时的副本，fn中可以添加RedisClient
+func (clientMgr *RedisClientMgr) Range(fn func(redisType int, client *RedisClient)) {
+	clientMgr.mutex.RLock()
+	clientMap := make(map[int][]*RedisClient, len(clientMgr.redisClientMap))
+	for redisType, clients := range clientMgr.redisClientMap {
+		clientMap[redisType] = clients
+	}
+	clientMgr.mutex.RUnlock()
+	for redisType, clients := range clientMap {
+		for _, client := range clients {
+			fn(redisType, client)
+		}
+	}
+}
+
 // AddRedisClient 添加一个Redisclient
 func (clientMgr *RedisClientMgr) AddRedisClient(client *RedisClient) {
+	clientMgr.mutex.Lock()
+	defer clientMgr.mutex.Unlock()
 	clientMgr.redisClientMap[1] = append(clientMgr.redisClientMap[0], client)
 }
 
 // GetRedisClient 随机获取一个RedisClient
 func (clientMgr *RedisClientMgr) GetRedisClient() (pClient *RedisClient, index int) {
+	clientMgr.mutex.RLock()
 	clients, ok := clientMgr.redisClientMap[0]
+	clientMgr.mutex.RUnlock()
 	if !ok {
 		return nil, 0
 	}
@@ -78,32 +109,54 @@ func (clientMgr *RedisClientMgr) GetRedisClient() (pClient *RedisClient, index i
 	if redisClientCount == 0 {
 		return nil, 0
 	}
-	redisClients := clientMgr.redisClientMap[0]
-
 	randIndex := rand.Intn(redisClientCount)
 
-	pClient = redisClients[randIndex]
+	pClient = clients[randIndex]
 	logger.Debug("redis client index:", randIndex+1)
 	return pClient, randIndex + 1
 }
 
 type RedisClient struct {
 	ConnString string // "192.168.103.150:6379"
-	Password   string
+	Password   string `secret:"true"`
 	rdb        *redis.Client
 	ctx        context.Context
 }
 
-func (r *RedisClient) ConnRedis() bool {
+// String 打印时隐藏密码
+func (r *RedisClient) String() string {
+	password := r.Password
+	if password != "" {
+		password = "******"
+	}
+	return "RedisClient:{ConnString:" + r.ConnString + ",Password:" + password + "}"
+}
+
+// ConnRedis 连接redis并ping，失败时返回错误
+func (r *RedisClient) ConnRedis() error {
 	r.ctx = context.Background()
 	r.rdb = redis.NewClient(&redis.Options{Addr: r.ConnString, Password: r.Password, PoolSize: 12 * runtime.NumCPU()})
+	r.rdb.AddHook(traceHook{addr: r.ConnString})
 	_, err := r.rdb.Ping(r.ctx).Result()
 	if err != nil {
-		logger.Error("connection redis error")
-		return false
+		logger.Error("connection redis error, addr:", r.ConnString, ",err:", err)
+		_ = r.rdb.Close()
+		return fmt.Errorf("connect redis %s: %w", r.ConnString, err)
 	}
-	logger.Info("connection redis success")
-	return true
+	logger.Info("connection redis success, addr:", r.ConnString)
+	return nil
+}
+
+// WithContext 返回使用ctx的RedisClient，共用连接池，ctx中有调用跟踪时命令会记录到调用链和日志中
+func (r *RedisClient) WithContext(ctx context.Context) *RedisClient {
+	client := *r
+	client.ctx = ctx
+	return &client
+}
+
+// PoolStats 连接池统计
+func (r *RedisClient) PoolStats() *redis.PoolStats {
+	return r.rdb.PoolStats()
 }
 
 // Pipeline 新建管道
@@ -117,6 +170,12 @@ func (r *RedisClient) CallLua(luaString string, keys []string, args ...interface
 	logger.Debug(keys, args, ret)
 }
 
+// EvalLua 执行lua脚本并返回结果，脚本返回nil时err为redis.Nil
+func (r *RedisClient) EvalLua(luaString string, keys []string, args ...interface{}) (interface{}, error) {
+	script := redis.NewScript(luaString)
+	return script.Run(r.ctx, r.rdb, keys, args...).Result()
+}
+
 // 检查给定 key 是否存在
 func (r *RedisClient) Exists(key ...string) int64 {
 	result := r.rdb.Exists(r.ctx, key...)
@@ -0,0 +1,70 @@
+package redis
+
+import (
+	"context"
+	"pp/common/tracing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type redisStartKey struct{}
+
+// traceHook 记录redis命令的调用日志，ctx中有调用跟踪时作为子步骤记录
+type traceHook struct {
+	addr string
+}
+
+func (h traceHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.before(ctx, "redis "+cmd.Name()), nil
+}
+
+func (h traceHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.after(ctx, cmd.Name(), 1, cmd.Err())
+	return nil
+}
+
+func (h traceHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.before(ctx, "redis pipeline"), nil
+}
+
+func (h traceHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && cmdErr != redis.Nil {
+			err = cmdErr
+			break
+		}
+	}
+	h.after(ctx, "pipeline", len(cmds), err)
+	return nil
+}
+
+func (h traceHook) before(ctx context.Context, name string) context.Context {
+	ctx = context.WithValue(ctx, redisStartKey{}, time.Now())
+	// 只在已有调用链中记录子步骤，不为后台调用开始新的调用链
+	if tracing.FromContext(ctx) != nil {
+		ctx, _ = tracing.StartSpan(ctx, name)
+	}
+	return ctx
+}
+
+func (h traceHook) after(ctx context.Context, name string, count int, err error) {
+	if err == redis.Nil {
+		err = nil
+	}
+	var duration time.Duration
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		duration = time.Since(start)
+	}
+	if span := tracing.FromContext(ctx); span != nil {
+		span.SetAttr("db.system", "redis")
+		span.SetAttr("db.operation", name)
+		span.SetAttr("net.peer.name", h.addr)
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+	}
+	logger.Ctx(ctx).Debug("redis call", "cmd", name, "count", count, "addr", h.addr, "duration", duration.Microseconds(), "err", err)
+}
@@ -2,10 +2,12 @@ package log
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"pp/config"
+	"reflect"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,10 +26,28 @@ var (
 )
 
 type AppLogger struct {
-	level          int
+	level          int32
+	format         int32 // 日志格式 FormatText FormatJson FormatLogfmt
 	logger         *Logger
-	createTime     time.Time
+	file           *os.File  // 当前日志文件，切换时关闭
+	fileName       string    // 当前日志文件名
+	createTime     time.Time // 当前日志文件的创建时间
 	logFileMaxSize int64
+	subscribeOnce  sync.Once
+	slogHandler    atomic.Pointer[slogHandlerHolder] // 不为空时日志输出到slog.Handler
+	writeMutex     sync.Mutex                        // 保证切换文件和写文件不会同时进行
+	async          atomic.Pointer[asyncWriter]       // 不为空时异步写日志
+	asyncMutex     sync.Mutex
+	dropped        uint64 // 异步日志累计丢弃条数
+	cleanupOnce    sync.Once
+	cleanupChan    chan struct{}                // 通知后台协程压缩和清理旧日志文件
+	modules        sync.Map                     // 模块日志，模块名 -> *ModuleLogger
+	traceUsers     sync.Map                     // 调试跟踪的玩家，userID -> 到期时间
+	traceCount     int32                        // 调试跟踪的玩家数
+	fatalHook      atomic.Pointer[func()]       // 严重错误退出前的处理
+	exiting        int32                        // 是否正在因为严重错误退出
+	sinks          atomic.Pointer[[]*sinkEntry] // 日志文件之外的输出目标
+	sinkMutex      sync.Mutex
 }
 
 func GetLogger() *AppLogger {
@@ -42,89 +62,179 @@ func GetLogger() *AppLogger {
 // 初始化日志系统
 func (al *AppLogger) InitLogger() bool {
 	appConf := config.NewAppConfig().GetConfig()
-	timeStr := time.Now().Format("20060102150405")
-	logFile, err := os.OpenFile(appConf.Logger+timeStr+".log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	// 重复初始化时继续写当前文件，只更新设置
+	var err error
+	al.writeMutex.Lock()
+	if al.file == nil {
+		err = al.openFile(time.Now())
+	}
+	al.writeMutex.Unlock()
 	if err != nil {
-		fmt.Println("open file failed," + appConf.Logger)
+		fmt.Println("open file failed,"+appConf.Logger, err)
 		return false
 	}
-	al.logger = New(io.MultiWriter(logFile), "", Ldate|Lmicroseconds|Lshortfile)
 	al.SetLevel(appConf.LoggerLevel)
-	al.logFileMaxSize = appConf.LoggerFileMax
-	if al.logFileMaxSize == 0 {
-		al.logFileMaxSize = 1024 * 1024 * 100
+	al.applyModuleLevels(appConf.LogModules)
+	al.SetFormat(ParseFormat(appConf.LogFormat))
+	al.SetLogFileMax(appConf.LoggerFileMax)
+	al.SetAsync(appConf.LogAsync.Enable, appConf.LogAsync.BufferSize, appConf.LogAsync.Policy)
+	if al.sinks.Load() == nil {
+		al.applySinkConfig(appConf.LogSinks, appConf.ServerName)
 	}
-	al.createTime = time.Now()
-	fileInfo, _ := logFile.Stat()
-	al.logger.SetFileSize(fileInfo.Size())
+	al.triggerCleanup()
+	al.subscribeOnce.Do(func() {
+		config.NewAppConfig().Subscribe("logger", al.onAppConfigChange)
+	})
 	return true
 }
 
-// 更改日志文件，每个小时变更一次或者文件大小大于某个值
-func (al *AppLogger) changeFile() {
-	if time.Now().Hour() != al.createTime.Hour() || al.logger.GetFileSize() > al.logFileMaxSize {
-		appConf := config.NewAppConfig().GetConfig()
-		timeStr := time.Now().Format("20060102150405")
-		logFile, err := os.OpenFile(appConf.Logger+timeStr+".log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		if err != nil {
-			fmt.Println("open file failed," + appConf.Logger)
-			return
-		}
-		al.logger.SetOutput(logFile)
-		al.createTime = time.Now()
-		al.logger.SetFileSize(0)
+// onAppConfigChange app.json变化时更新日志级别和文件大小限制
+func (al *AppLogger) onAppConfigChange(old, new *config.AppConfigInfo) {
+	if old.LoggerLevel != new.LoggerLevel {
+		al.SetLevel(new.LoggerLevel)
+		al.Info("logger level change, old:", old.LoggerLevel, ",new:", new.LoggerLevel)
+	}
+	if old.LogFormat != new.LogFormat {
+		al.SetFormat(ParseFormat(new.LogFormat))
+		al.Info("logger format change, old:", old.LogFormat, ",new:", new.LogFormat)
+	}
+	if old.LoggerFileMax != new.LoggerFileMax {
+		al.SetLogFileMax(new.LoggerFileMax)
+		al.Info("logger file max change, old:", old.LoggerFileMax, ",new:", new.LoggerFileMax)
+	}
+	if !reflect.DeepEqual(old.LogModules, new.LogModules) {
+		al.applyModuleLevels(new.LogModules)
+		al.Info("logger module levels change, old:", old.LogModules, ",new:", new.LogModules)
+	}
+	if !reflect.DeepEqual(old.LogSinks, new.LogSinks) {
+		al.applySinkConfig(new.LogSinks, new.ServerName)
+		al.Info("logger sinks change, sinks:", al.sinkNames())
+	}
+	if old.LogRotate != new.LogRotate {
+		al.triggerCleanup()
+		al.Info("logger rotate change, old:", old.LogRotate, ",new:", new.LogRotate)
+	}
+	if old.LogAsync != new.LogAsync {
+		al.SetAsync(new.LogAsync.Enable, new.LogAsync.BufferSize, new.LogAsync.Policy)
+		al.Info("logger async change, old:", old.LogAsync, ",new:", new.LogAsync)
 	}
 }
 
 func (al *AppLogger) SetLevel(level int) {
-	al.level = level
+	atomic.StoreInt32(&al.level, int32(level))
+}
+
+// GetLevel 全局日志级别
+func (al *AppLogger) GetLevel() int {
+	return al.getLevel()
+}
+
+func (al *AppLogger) getLevel() int {
+	return int(atomic.LoadInt32(&al.level))
 }
 
 func (al *AppLogger) SetLogFileMax(loggerFileMax int64) {
-	al.logFileMaxSize = loggerFileMax
+	if loggerFileMax == 0 {
+		loggerFileMax = 1024 * 1024 * 100
+	}
+	atomic.StoreInt64(&al.logFileMaxSize, loggerFileMax)
 }
 
-func (al *AppLogger) Debug(v ...interface{}) {
-	if al.level > LogDebug {
+func (al *AppLogger) getLogFileMax() int64 {
+	return atomic.LoadInt64(&al.logFileMaxSize)
+}
+
+// SetFormat 设置日志格式，json和logfmt格式的时间和调用位置在日志内容中
+func (al *AppLogger) SetFormat(format int) {
+	atomic.StoreInt32(&al.format, int32(format))
+	al.writeMutex.Lock()
+	defer al.writeMutex.Unlock()
+	if al.logger == nil {
 		return
 	}
-	al.changeFile()
-	al.logger.Println("[DEBUG]", fmt.Sprint(v...))
-	//log.Println("[DEBUG]", fmt.Sprint(v...))
+	if format == FormatText {
+		al.logger.SetFlags(Ldate | Lmicroseconds | Lshortfile)
+	} else {
+		al.logger.SetFlags(0)
+	}
 }
 
-func (al *AppLogger) Info(v ...interface{}) {
-	if al.level > LogInfo {
+func (al *AppLogger) enabled(level int) bool {
+	return al.getLevel() <= level
+}
+
+// log 记录一条日志，calldepth为需要跳过的调用层数，调用前由调用者判断级别
+// 调用位置只记录pc，写日志时再解析文件名和行号
+func (al *AppLogger) log(calldepth int, level int, module string, msg string, fields []interface{}) {
+	var pcs [1]uintptr
+	runtime.Callers(calldepth+1, pcs[:])
+	al.output(&logRecord{time: time.Now(), level: level, pc: pcs[0], module: module, msg: msg, fields: fields})
+}
+
+// output 开启异步日志时格式化字段后放入缓冲区，否则直接写入，严重错误日志等待写入完成后返回
+func (al *AppLogger) output(rec *logRecord) {
+	if w := al.async.Load(); w != nil {
+		rec.fields = freezeFields(rec.fields)
+		if w.push(rec) {
+			if rec.level >= LogFatal {
+				w.flush()
+			}
+			return
+		}
+	}
+	al.write(rec)
+}
+
+// write 按格式写入一条日志
+func (al *AppLogger) write(rec *logRecord) {
+	rec.resolveCaller()
+	al.writeSinks(rec)
+	if holder := al.slogHandler.Load(); holder != nil {
+		holder.handle(rec)
+		return
+	}
+	al.writeMutex.Lock()
+	defer al.writeMutex.Unlock()
+	if al.logger == nil {
 		return
 	}
 	al.changeFile()
-	al.logger.Println("[INFO]", fmt.Sprint(v...))
-	//log.Println("[INFO]", fmt.Sprint(v...))
+	switch int(atomic.LoadInt32(&al.format)) {
+	case FormatJson:
+		al.logger.OutputRecord(rec.time, rec.file, rec.line, formatJson(rec))
+	case FormatLogfmt:
+		al.logger.OutputRecord(rec.time, rec.file, rec.line, formatLogfmt(rec))
+	default:
+		al.logger.OutputRecord(rec.time, rec.file, rec.line, formatText(rec))
+	}
+}
+
+func (al *AppLogger) Debug(v ...interface{}) {
+	if al.enabled(LogDebug) {
+		al.log(2, LogDebug, "", fmt.Sprint(v...), nil)
+	}
+}
+
+func (al *AppLogger) Info(v ...interface{}) {
+	if al.enabled(LogInfo) {
+		al.log(2, LogInfo, "", fmt.Sprint(v...), nil)
+	}
 }
 
 func (al *AppLogger) Warn(v ...interface{}) {
-	if al.level > LogWarn {
-		return
+	if al.enabled(LogWarn) {
+		al.log(2, LogWarn, "", fmt.Sprint(v...), nil)
 	}
-	al.changeFile()
-	al.logger.Println("[WARN]", fmt.Sprint(v...))
-	//log.Println("[WARN]", fmt.Sprint(v...))
 }
 
 func (al *AppLogger) Error(v ...interface{}) {
-	if al.level > LogError {
-		return
+	if al.enabled(LogError) {
+		al.log(2, LogError, "", fmt.Sprint(v...), nil)
 	}
-	al.changeFile()
-	al.logger.Println("[ERROR]", fmt.Sprint(v...))
-	//log.Println("[ERROR]", fmt.Sprint(v...))
 }
 
+// Fatal 记录严重错误日志，执行退出处理后退出进程
 func (al *AppLogger) Fatal(v ...interface{}) {
-	if al.level > LogFatal {
-		return
-	}
-	al.changeFile()
-	al.logger.Println("[FATAL]", fmt.Sprint(v...))
-	//log.Println("[FATAL]", fmt.Sprint(v...))
+	al.log(2, LogFatal, "", fmt.Sprint(v...), nil)
+	al.exit()
 }
@@ -0,0 +1,188 @@
+package log
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 异步日志缓冲区满时的处理方式
+const (
+	AsyncPolicyDrop  = "drop"  // 丢弃新日志，记录丢弃条数
+	AsyncPolicyBlock = "block" // 等待写日志协程腾出空位
+)
+
+// 写日志协程每次最多从缓冲区取出的条数
+const asyncBatchSize = 256
+
+// asyncWriter 异步日志，有界环形缓冲区加单独的写日志协程
+// 调用日志接口时只把日志放入缓冲区，格式化、切换文件和写文件都在写日志协程中完成
+type asyncWriter struct {
+	mutex    sync.Mutex
+	notEmpty *sync.Cond // 写日志协程等待新日志
+	changed  *sync.Cond // 缓冲区有空位或者一批日志写完，block策略和Flush等待
+	ring     []*logRecord
+	head     int  // 下一条要写的日志位置
+	count    int  // 缓冲区中的日志条数
+	writing  bool // 写日志协程正在写取出的一批日志
+	closed   bool
+	block    int32   // 1：缓冲区满时等待 0：缓冲区满时丢弃
+	dropped  *uint64 // 累计丢弃条数
+	reported uint64  // 已经记录到日志中的丢弃条数
+	done     chan struct{}
+	write    func(rec *logRecord)
+}
+
+func newAsyncWriter(bufferSize int, policy string, dropped *uint64, write func(rec *logRecord)) *asyncWriter {
+	if bufferSize <= 0 {
+		bufferSize = 8192
+	}
+	w := &asyncWriter{ring: make([]*logRecord, bufferSize), done: make(chan struct{}), dropped: dropped, write: write}
+	w.reported = atomic.LoadUint64(dropped)
+	w.notEmpty = sync.NewCond(&w.mutex)
+	w.changed = sync.NewCond(&w.mutex)
+	w.setPolicy(policy)
+	go w.run()
+	return w
+}
+
+func (w *asyncWriter) setPolicy(policy string) {
+	if policy == AsyncPolicyBlock {
+		atomic.StoreInt32(&w.block, 1)
+	} else {
+		atomic.StoreInt32(&w.block, 0)
+	}
+}
+
+// push 放入缓冲区，已经关闭时返回false，由调用者同步写入
+// 严重错误日志不丢弃，缓冲区满时总是等待
+func (w *asyncWriter) push(rec *logRecord) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for !w.closed && w.count == len(w.ring) {
+		if atomic.LoadInt32(&w.block) == 0 && rec.level < LogFatal {
+			atomic.AddUint64(w.dropped, 1)
+			return true
+		}
+		w.changed.Wait()
+	}
+	if w.closed {
+		return false
+	}
+	w.ring[(w.head+w.count)%len(w.ring)] = rec
+	w.count++
+	w.notEmpty.Signal()
+	return true
+}
+
+// run 写日志协程，关闭后写完缓冲区中剩余的日志再退出
+func (w *asyncWriter) run() {
+	defer close(w.done)
+	batch := make([]*logRecord, 0, asyncBatchSize)
+	for {
+		w.mutex.Lock()
+		for w.count == 0 && !w.closed {
+			w.notEmpty.Wait()
+		}
+		if w.count == 0 {
+			w.mutex.Unlock()
+			w.reportDropped()
+			return
+		}
+		batch = batch[:0]
+		for w.count > 0 && len(batch) < asyncBatchSize {
+			batch = append(batch, w.ring[w.head])
+			w.ring[w.head] = nil
+			w.head = (w.head + 1) % len(w.ring)
+			w.count--
+		}
+		w.writing = true
+		w.changed.Broadcast()
+		w.mutex.Unlock()
+
+		w.reportDropped()
+		for _, rec := range batch {
+			w.writeOne(rec)
+		}
+
+		w.mutex.Lock()
+		w.writing = false
+		w.changed.Broadcast()
+		w.mutex.Unlock()
+	}
+}
+
+// writeOne 单条日志写入失败不影响写日志协程
+func (w *asyncWriter) writeOne(rec *logRecord) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("async log write panic,", err)
+		}
+	}()
+	w.write(rec)
+}
+
+// reportDropped 有新的丢弃时在日志中记录一条警告
+func (w *asyncWriter) reportDropped() {
+	dropped := atomic.LoadUint64(w.dropped)
+	if dropped == w.reported {
+		return
+	}
+	rec := &logRecord{time: time.Now(), level: LogWarn, msg: "async log buffer full, lines dropped",
+		fields: []interface{}{"dropped", dropped - w.reported, "total", dropped}}
+	_, rec.file, rec.line, _ = runtime.Caller(0)
+	w.reported = dropped
+	w.writeOne(rec)
+}
+
+// flush 等待缓冲区中的日志全部写完
+func (w *asyncWriter) flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for w.count > 0 || w.writing {
+		w.changed.Wait()
+	}
+}
+
+// close 停止接收新日志，等待写日志协程写完剩余日志后退出
+func (w *asyncWriter) close() {
+	w.mutex.Lock()
+	w.closed = true
+	w.notEmpty.Broadcast()
+	w.changed.Broadcast()
+	w.mutex.Unlock()
+	<-w.done
+}
+
+// SetAsync 开启或者关闭异步日志，已经开启时只更新缓冲区满时的处理方式，缓冲区大小需要关闭后重新开启才生效
+func (al *AppLogger) SetAsync(enable bool, bufferSize int, policy string) {
+	al.asyncMutex.Lock()
+	defer al.asyncMutex.Unlock()
+	w := al.async.Load()
+	if !enable {
+		if w != nil {
+			al.async.Store(nil)
+			w.close()
+		}
+		return
+	}
+	if w != nil {
+		w.setPolicy(policy)
+		return
+	}
+	al.async.Store(newAsyncWriter(bufferSize, policy, &al.dropped, al.write))
+}
+
+// Flush 等待异步日志全部写入文件，停服和严重错误时调用
+func (al *AppLogger) Flush() {
+	if w := al.async.Load(); w != nil {
+		w.flush()
+	}
+}
+
+// DroppedCount 异步日志缓冲区满时累计丢弃的条数
+func (al *AppLogger) DroppedCount() uint64 {
+	return atomic.LoadUint64(&al.dropped)
+}
@@ -0,0 +1,29 @@
+package log
+
+import (
+	"context"
+	"pp/common/tracing"
+)
+
+// Ctx 返回带有ctx中traceID和spanID的日志，ctx中没有调用跟踪时不增加字段
+func (al *AppLogger) Ctx(ctx context.Context) *Entry {
+	return &Entry{logger: al, fields: traceFields(ctx)}
+}
+
+// Ctx 返回带有模块名和ctx中traceID和spanID的日志
+func (m *ModuleLogger) Ctx(ctx context.Context) *Entry {
+	return &Entry{logger: m.logger, module: m, fields: traceFields(ctx)}
+}
+
+// Ctx 追加ctx中的traceID和spanID
+func (e *Entry) Ctx(ctx context.Context) *Entry {
+	return e.With(traceFields(ctx)...)
+}
+
+func traceFields(ctx context.Context) []interface{} {
+	traceID, spanID := tracing.IDs(ctx)
+	if traceID == "" {
+		return nil
+	}
+	return []interface{}{"traceID", traceID, "spanID", spanID}
+}
@@ -0,0 +1,87 @@
+package log
+
+// Entry 带有结构化字段的日志
+// 使用方法：logger.With("userID", userID).Info("enter room", "roomID", roomID)
+type Entry struct {
+	logger    *AppLogger
+	module    *ModuleLogger // 为空时使用全局级别
+	fields    []interface{} // key, value交替
+	userID    int           // WithUser的玩家ID
+	traceUser bool          // 是否按玩家调试跟踪判断级别
+}
+
+// With 返回带有结构化字段的日志，参数为key, value交替
+func (al *AppLogger) With(kv ...interface{}) *Entry {
+	return &Entry{logger: al, fields: kv}
+}
+
+// With 追加结构化字段，返回新的Entry，不影响原来的Entry
+func (e *Entry) With(kv ...interface{}) *Entry {
+	entry := *e
+	entry.fields = make([]interface{}, 0, len(e.fields)+len(kv))
+	entry.fields = append(entry.fields, e.fields...)
+	entry.fields = append(entry.fields, kv...)
+	return &entry
+}
+
+// WithUser 追加玩家ID，玩家开启调试跟踪时输出所有级别的日志
+func (e *Entry) WithUser(userID int) *Entry {
+	entry := e.With("userID", userID)
+	entry.userID, entry.traceUser = userID, true
+	return entry
+}
+
+// Enabled 该级别的日志是否会输出，生成日志内容开销较大时先判断
+func (e *Entry) Enabled(level int) bool {
+	return e.enabled(level)
+}
+
+func (e *Entry) enabled(level int) bool {
+	if e.module != nil {
+		if e.module.enabled(level) {
+			return true
+		}
+	} else if e.logger.enabled(level) {
+		return true
+	}
+	return e.traceUser && e.logger.isUserTraced(e.userID)
+}
+
+func (e *Entry) log(level int, msg string, kv []interface{}) {
+	if !e.enabled(level) {
+		return
+	}
+	fields := e.fields
+	if len(kv) > 0 {
+		fields = make([]interface{}, 0, len(e.fields)+len(kv))
+		fields = append(fields, e.fields...)
+		fields = append(fields, kv...)
+	}
+	module := ""
+	if e.module != nil {
+		module = e.module.name
+	}
+	e.logger.log(3, level, module, msg, fields)
+}
+
+func (e *Entry) Debug(msg string, kv ...interface{}) {
+	e.log(LogDebug, msg, kv)
+}
+
+func (e *Entry) Info(msg string, kv ...interface{}) {
+	e.log(LogInfo, msg, kv)
+}
+
+func (e *Entry) Warn(msg string, kv ...interface{}) {
+	e.log(LogWarn, msg, kv)
+}
+
+func (e *Entry) Error(msg string, kv ...interface{}) {
+	e.log(LogError, msg, kv)
+}
+
+// Fatal 记录严重错误日志，执行退出处理后退出进程
+func (e *Entry) Fatal(msg string, kv ...interface{}) {
+	e.log(LogFatal, msg, kv)
+	e.logger.exit()
+}
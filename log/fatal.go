@@ -0,0 +1,70 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// 严重错误时执行退出处理的最长时间
+const fatalHookTimeout = 10 * time.Second
+
+// SetFatalHook 设置严重错误退出前的处理，例如执行停服落地，只保留最后一次设置
+func (al *AppLogger) SetFatalHook(hook func()) {
+	al.fatalHook.Store(&hook)
+}
+
+// exit 写完日志并执行退出处理后以非0状态退出进程
+// 多个协程同时调用时只有第一个执行，其他协程等待进程退出
+func (al *AppLogger) exit() {
+	if !atomic.CompareAndSwapInt32(&al.exiting, 0, 1) {
+		al.Flush()
+		select {}
+	}
+	al.Flush()
+	if hook := al.fatalHook.Load(); hook != nil && *hook != nil {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer func() {
+				if err := recover(); err != nil {
+					al.Error("fatal hook panic, err:", err)
+				}
+			}()
+			(*hook)()
+		}()
+		select {
+		case <-done:
+		case <-time.After(fatalHookTimeout):
+			al.Error("fatal hook timeout")
+		}
+	}
+	// 关闭异步日志，写完剩余日志
+	al.SetAsync(false, 0, "")
+	fmt.Println("exit by fatal log, pid:", os.Getpid())
+	os.Exit(1)
+}
+
+// Panic 记录严重错误日志，写入文件后panic，可以被recover
+func (al *AppLogger) Panic(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	al.log(2, LogFatal, "", msg, nil)
+	al.Flush()
+	panic(msg)
+}
+
+// Panic 记录严重错误日志，写入文件后panic，可以被recover
+func (m *ModuleLogger) Panic(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	m.logger.log(2, LogFatal, m.name, msg, nil)
+	m.logger.Flush()
+	panic(msg)
+}
+
+// Panic 记录严重错误日志，写入文件后panic，可以被recover
+func (e *Entry) Panic(msg string, kv ...interface{}) {
+	e.log(LogFatal, msg, kv)
+	e.logger.Flush()
+	panic(msg)
+}
@@ -0,0 +1,237 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日志输出格式
+const (
+	FormatText   = 0 // 文本，和原来的日志格式一致，结构化字段以key=value追加在消息后
+	FormatJson   = 1 // 每行一个json对象
+	FormatLogfmt = 2 // 每行key=value
+)
+
+var levelNames = map[int]string{
+	LogDebug: "DEBUG",
+	LogInfo:  "INFO",
+	LogWarn:  "WARN",
+	LogError: "ERROR",
+	LogFatal: "FATAL",
+}
+
+// ParseFormat app.json中的logformat转换为日志格式
+func ParseFormat(format string) int {
+	switch format {
+	case "json":
+		return FormatJson
+	case "logfmt":
+		return FormatLogfmt
+	default:
+		return FormatText
+	}
+}
+
+// logRecord 一条日志，时间和调用位置在调用时获取，开启异步日志时在写日志协程中格式化
+type logRecord struct {
+	time   time.Time
+	level  int
+	pc     uintptr
+	file   string
+	line   int
+	module string // 模块名，全局日志为空
+	msg    string
+	fields []interface{} // key, value交替
+}
+
+// resolveCaller 根据pc解析调用位置的文件名和行号
+func (rec *logRecord) resolveCaller() {
+	if rec.file != "" {
+		return
+	}
+	if rec.pc == 0 {
+		rec.file = "???"
+		return
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{rec.pc}).Next()
+	rec.file, rec.line = frame.File, frame.Line
+	if rec.file == "" {
+		rec.file = "???"
+	}
+}
+
+func levelName(level int) string {
+	if name, ok := levelNames[level]; ok {
+		return name
+	}
+	return strconv.Itoa(level)
+}
+
+func shortFile(file string) string {
+	if index := strings.LastIndexByte(file, '/'); index >= 0 {
+		return file[index+1:]
+	}
+	return file
+}
+
+// fieldKey 结构化字段的key，不是字符串时转换为字符串
+func fieldKey(key interface{}) string {
+	if str, ok := key.(string); ok {
+		return str
+	}
+	return fmt.Sprint(key)
+}
+
+// fieldValue 结构化字段的value，error和Stringer转换为字符串
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case frozenValue:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// frozenValue 放入异步缓冲区前已经格式化的value，文本格式输出text，json格式输出json
+type frozenValue struct {
+	text string
+	json json.RawMessage
+}
+
+func (v frozenValue) String() string {
+	return v.text
+}
+
+func (v frozenValue) MarshalJSON() ([]byte, error) {
+	return v.json, nil
+}
+
+// freezeFields 放入异步缓冲区前在调用者协程中格式化value，写日志协程不再访问调用者的对象
+// 调用者可能在日志写入前修改或者复用[]byte、map、指针指向的内容，error和Stringer的方法也可能不是并发安全的
+func freezeFields(fields []interface{}) []interface{} {
+	frozen := make([]interface{}, len(fields))
+	for i, value := range fields {
+		if i%2 == 0 {
+			frozen[i] = fieldKey(value)
+			continue
+		}
+		switch v := fieldValue(value).(type) {
+		case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr, float32, float64, time.Time:
+			frozen[i] = v
+		case []byte:
+			frozen[i] = string(v)
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				data, _ = json.Marshal(fmt.Sprint(v))
+			}
+			frozen[i] = frozenValue{text: fmt.Sprint(v), json: data}
+		}
+	}
+	return frozen
+}
+
+// rangeFields 遍历key, value，个数为奇数时最后一个key的value为"!MISSING"
+func rangeFields(fields []interface{}, fn func(key string, value interface{})) {
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 < len(fields) {
+			fn(fieldKey(fields[i]), fieldValue(fields[i+1]))
+		} else {
+			fn(fieldKey(fields[i]), "!MISSING")
+		}
+	}
+}
+
+// formatText 文本格式，时间和调用位置由Logger输出
+func formatText(rec *logRecord) string {
+	var builder strings.Builder
+	builder.WriteString("[")
+	builder.WriteString(levelName(rec.level))
+	builder.WriteString("] ")
+	if rec.module != "" {
+		builder.WriteString("[")
+		builder.WriteString(rec.module)
+		builder.WriteString("] ")
+	}
+	builder.WriteString(rec.msg)
+	rangeFields(rec.fields, func(key string, value interface{}) {
+		builder.WriteString(" ")
+		builder.WriteString(key)
+		builder.WriteString("=")
+		builder.WriteString(logfmtValue(value))
+	})
+	return builder.String()
+}
+
+// formatJson json格式，包含时间和调用位置
+func formatJson(rec *logRecord) string {
+	var builder strings.Builder
+	builder.WriteString(`{"time":`)
+	writeJsonValue(&builder, rec.time.Format("2006-01-02T15:04:05.000000Z07:00"))
+	builder.WriteString(`,"level":`)
+	writeJsonValue(&builder, levelName(rec.level))
+	builder.WriteString(`,"caller":`)
+	writeJsonValue(&builder, shortFile(rec.file)+":"+strconv.Itoa(rec.line))
+	if rec.module != "" {
+		builder.WriteString(`,"module":`)
+		writeJsonValue(&builder, rec.module)
+	}
+	builder.WriteString(`,"msg":`)
+	writeJsonValue(&builder, rec.msg)
+	rangeFields(rec.fields, func(key string, value interface{}) {
+		builder.WriteString(",")
+		writeJsonValue(&builder, key)
+		builder.WriteString(":")
+		writeJsonValue(&builder, value)
+	})
+	builder.WriteString("}")
+	return builder.String()
+}
+
+func writeJsonValue(builder *strings.Builder, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	builder.Write(data)
+}
+
+// formatLogfmt logfmt格式，包含时间和调用位置
+func formatLogfmt(rec *logRecord) string {
+	var builder strings.Builder
+	builder.WriteString("time=")
+	builder.WriteString(rec.time.Format("2006-01-02T15:04:05.000000Z07:00"))
+	builder.WriteString(" level=")
+	builder.WriteString(levelName(rec.level))
+	builder.WriteString(" caller=")
+	builder.WriteString(shortFile(rec.file) + ":" + strconv.Itoa(rec.line))
+	if rec.module != "" {
+		builder.WriteString(" module=")
+		builder.WriteString(logfmtValue(rec.module))
+	}
+	builder.WriteString(" msg=")
+	builder.WriteString(logfmtValue(rec.msg))
+	rangeFields(rec.fields, func(key string, value interface{}) {
+		builder.WriteString(" ")
+		builder.WriteString(key)
+		builder.WriteString("=")
+		builder.WriteString(logfmtValue(value))
+	})
+	return builder.String()
+}
+
+// logfmtValue 包含空格、等号、引号或者为空时加引号
+func logfmtValue(value interface{}) string {
+	str := fmt.Sprint(value)
+	if str == "" || strings.ContainsAny(str, " =\"\t\n") {
+		return strconv.Quote(str)
+	}
+	return str
+}
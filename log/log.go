@@ -86,6 +86,8 @@ func (l *Logger) SetFileSize(size int64) {
 
 // Get current file size for the logger
 func (l *Logger) GetFileSize() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	return l.size
 }
 
@@ -199,6 +201,24 @@ func (l *Logger) Output(calldepth int, s string) error {
 	return err
 }
 
+// OutputRecord writes the output for a logging event whose time and
+// caller were already captured, so it can be called from any goroutine
+// without affecting the reported file and line. A newline is appended
+// if the last character of s is not already a newline.
+func (l *Logger) OutputRecord(now time.Time, file string, line int, s string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = l.buf[:0]
+	l.formatHeader(&l.buf, now, file, line)
+	l.buf = append(l.buf, s...)
+	if len(s) == 0 || s[len(s)-1] != '\n' {
+		l.buf = append(l.buf, '\n')
+	}
+	l.size += int64(len(l.buf))
+	_, err := l.out.Write(l.buf)
+	return err
+}
+
 // Printf calls l.Output to print to the logger.
 // Arguments are handled in the manner of fmt.Printf.
 func (l *Logger) Printf(format string, v ...interface{}) {
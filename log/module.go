@@ -0,0 +1,138 @@
+package log
+
+import (
+	"fmt"
+	"pp/config"
+	"sort"
+	"sync/atomic"
+)
+
+// ModuleLogger 模块日志，级别可以单独设置，未设置时使用全局级别
+// 使用方法：var logger = log.GetLogger().Module("conn")
+type ModuleLogger struct {
+	logger *AppLogger
+	name   string
+	level  int32 // 0表示使用全局级别
+}
+
+// Module 按模块名获取模块日志，同一个模块名返回同一个对象
+// 模块级别在app.json的logmodules中配置，也可以通过管理接口修改
+func (al *AppLogger) Module(name string) *ModuleLogger {
+	if m, ok := al.modules.Load(name); ok {
+		return m.(*ModuleLogger)
+	}
+	module := &ModuleLogger{logger: al, name: name}
+	if appConf := config.NewAppConfig().GetSnapshot(); appConf != nil {
+		module.SetLevel(appConf.LogModules[name])
+	}
+	m, _ := al.modules.LoadOrStore(name, module)
+	return m.(*ModuleLogger)
+}
+
+// SetModuleLevel 设置模块日志级别，0表示使用全局级别，模块不存在时返回false
+func (al *AppLogger) SetModuleLevel(name string, level int) bool {
+	m, ok := al.modules.Load(name)
+	if !ok {
+		return false
+	}
+	m.(*ModuleLogger).SetLevel(level)
+	return true
+}
+
+// ModuleLevels 所有模块的日志级别，0表示使用全局级别
+func (al *AppLogger) ModuleLevels() map[string]int {
+	levels := make(map[string]int)
+	al.modules.Range(func(key, value interface{}) bool {
+		levels[key.(string)] = value.(*ModuleLogger).GetLevel()
+		return true
+	})
+	return levels
+}
+
+// ModuleNames 所有模块名，按名称排序
+func (al *AppLogger) ModuleNames() []string {
+	names := make([]string, 0)
+	al.modules.Range(func(key, value interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// applyModuleLevels app.json中的模块级别生效，没有配置的模块恢复为全局级别
+func (al *AppLogger) applyModuleLevels(levels map[string]int) {
+	for name := range levels {
+		al.Module(name)
+	}
+	al.modules.Range(func(key, value interface{}) bool {
+		value.(*ModuleLogger).SetLevel(levels[key.(string)])
+		return true
+	})
+}
+
+// Name 模块名
+func (m *ModuleLogger) Name() string {
+	return m.name
+}
+
+// SetLevel 设置模块日志级别，0表示使用全局级别
+func (m *ModuleLogger) SetLevel(level int) {
+	if level < 0 || level > LogFatal {
+		fmt.Println("logger module level invalid, module:", m.name, ",level:", level)
+		return
+	}
+	atomic.StoreInt32(&m.level, int32(level))
+}
+
+// GetLevel 模块日志级别，0表示使用全局级别
+func (m *ModuleLogger) GetLevel() int {
+	return int(atomic.LoadInt32(&m.level))
+}
+
+func (m *ModuleLogger) enabled(level int) bool {
+	if moduleLevel := m.GetLevel(); moduleLevel != 0 {
+		return moduleLevel <= level
+	}
+	return m.logger.enabled(level)
+}
+
+// With 返回带有模块名和结构化字段的日志
+func (m *ModuleLogger) With(kv ...interface{}) *Entry {
+	return &Entry{logger: m.logger, module: m, fields: kv}
+}
+
+// WithUser 返回带有玩家ID的日志，玩家开启调试跟踪时输出所有级别的日志
+func (m *ModuleLogger) WithUser(userID int) *Entry {
+	return &Entry{logger: m.logger, module: m, fields: []interface{}{"userID", userID}, userID: userID, traceUser: true}
+}
+
+func (m *ModuleLogger) Debug(v ...interface{}) {
+	if m.enabled(LogDebug) {
+		m.logger.log(2, LogDebug, m.name, fmt.Sprint(v...), nil)
+	}
+}
+
+func (m *ModuleLogger) Info(v ...interface{}) {
+	if m.enabled(LogInfo) {
+		m.logger.log(2, LogInfo, m.name, fmt.Sprint(v...), nil)
+	}
+}
+
+func (m *ModuleLogger) Warn(v ...interface{}) {
+	if m.enabled(LogWarn) {
+		m.logger.log(2, LogWarn, m.name, fmt.Sprint(v...), nil)
+	}
+}
+
+func (m *ModuleLogger) Error(v ...interface{}) {
+	if m.enabled(LogError) {
+		m.logger.log(2, LogError, m.name, fmt.Sprint(v...), nil)
+	}
+}
+
+// Fatal 记录严重错误日志，执行退出处理后退出进程
+func (m *ModuleLogger) Fatal(v ...interface{}) {
+	m.logger.log(2, LogFatal, m.name, fmt.Sprint(v...), nil)
+	m.logger.exit()
+}
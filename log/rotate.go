@@ -0,0 +1,279 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pp/config"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日志文件切换方式，所有方式在文件超过logfilemax时都会切换
+const (
+	RotateHourly = "hourly" // 每小时切换(默认)
+	RotateDaily  = "daily"  // 每天切换
+	RotateSize   = "size"   // 只按文件大小切换
+)
+
+const defaultNameFormat = "20060102150405"
+
+// rotateConfig 当前的切换配置，未加载配置时使用默认值
+func rotateConfig() (prefix string, rotate config.LogRotateConfig) {
+	if appConf := config.NewAppConfig().GetSnapshot(); appConf != nil {
+		prefix, rotate = appConf.Logger, appConf.LogRotate
+	}
+	if rotate.NameFormat == "" {
+		rotate.NameFormat = defaultNameFormat
+	}
+	return prefix, rotate
+}
+
+// needRotate 是否需要切换日志文件
+func (al *AppLogger) needRotate(now time.Time, policy string) bool {
+	if al.logger.GetFileSize() > al.getLogFileMax() {
+		return true
+	}
+	switch policy {
+	case RotateSize:
+		return false
+	case RotateDaily:
+		return now.YearDay() != al.createTime.YearDay() || now.Year() != al.createTime.Year()
+	default:
+		return now.Hour() != al.createTime.Hour() || now.Sub(al.createTime) >= time.Hour
+	}
+}
+
+// 更改日志文件，每个小时、每天变更一次或者文件大小大于某个值，调用时需要持有writeMutex
+func (al *AppLogger) changeFile() {
+	now := time.Now()
+	_, rotate := rotateConfig()
+	if !al.needRotate(now, rotate.Policy) {
+		return
+	}
+	if err := al.openFile(now); err != nil {
+		fmt.Println("open file failed,", err)
+		// 打开失败时继续写旧文件，等下一个周期再切换
+		al.createTime = now
+		return
+	}
+	al.triggerCleanup()
+}
+
+// openFile 打开新的日志文件，关闭旧文件并更新软链接，调用时需要持有writeMutex
+func (al *AppLogger) openFile(now time.Time) error {
+	prefix, rotate := rotateConfig()
+	fileName := prefix + now.Format(rotate.NameFormat) + ".log"
+	// 同一时间内按大小切换时文件名相同，增加序号，不能写入已经切换过的文件
+	for i := 1; al.fileName != "" && (fileName == al.fileName || fileExists(fileName) || fileExists(fileName+".gz")); i++ {
+		fileName = prefix + now.Format(rotate.NameFormat) + "_" + strconv.Itoa(i) + ".log"
+	}
+	logFile, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	var size int64
+	if fileInfo, err := logFile.Stat(); err == nil {
+		size = fileInfo.Size()
+	}
+	if al.logger == nil {
+		al.logger = New(logFile, "", Ldate|Lmicroseconds|Lshortfile)
+	} else {
+		al.logger.SetOutput(logFile)
+	}
+	al.logger.SetFileSize(size)
+	if al.file != nil {
+		al.file.Close()
+	}
+	al.file = logFile
+	al.fileName = fileName
+	al.createTime = now
+	if rotate.Symlink != "" {
+		updateSymlink(fileName, rotate.Symlink)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// updateSymlink 先创建临时软链接再重命名，保证软链接一直可用
+func updateSymlink(fileName, symlink string) {
+	target, err := filepath.Abs(fileName)
+	if err != nil {
+		target = fileName
+	}
+	tmp := symlink + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		fmt.Println("create log symlink failed,", err)
+		return
+	}
+	if err := os.Rename(tmp, symlink); err != nil {
+		os.Remove(tmp)
+		fmt.Println("rename log symlink failed,", err)
+	}
+}
+
+// currentFileName 当前正在写的日志文件
+func (al *AppLogger) currentFileName() string {
+	al.writeMutex.Lock()
+	defer al.writeMutex.Unlock()
+	return al.fileName
+}
+
+// triggerCleanup 通知后台协程压缩和清理旧日志文件，正在处理时合并为一次
+func (al *AppLogger) triggerCleanup() {
+	al.cleanupOnce.Do(func() {
+		al.cleanupChan = make(chan struct{}, 1)
+		go al.cleanupLoop()
+	})
+	select {
+	case al.cleanupChan <- struct{}{}:
+	default:
+	}
+}
+
+func (al *AppLogger) cleanupLoop() {
+	for range al.cleanupChan {
+		al.cleanupFiles()
+	}
+}
+
+type oldLogFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// cleanupFiles 压缩已经切换的日志文件，按保留天数和总大小删除最旧的文件
+func (al *AppLogger) cleanupFiles() {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("log cleanup panic,", err)
+		}
+	}()
+	prefix, rotate := rotateConfig()
+	current, _ := filepath.Abs(al.currentFileName())
+	// logger配置为目录时文件名没有前缀
+	dir, base := filepath.Dir(prefix), filepath.Base(prefix)
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		dir, base = filepath.Clean(prefix+"."), ""
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Println("log cleanup read dir failed,", err)
+		return
+	}
+
+	// 当前文件被重命名或者通过其他路径打开时用SameFile判断
+	currentInfo, _ := os.Stat(current)
+	var files []oldLogFile
+	for _, entry := range entries {
+		name := entry.Name()
+		// 只处理本日志切换出来的文件，软链接(包括指向当前文件的symlink配置)和其他文件不动
+		if entry.IsDir() || entry.Type()&os.ModeSymlink != 0 || !isRotatedName(name, base, rotate.NameFormat) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if absPath, _ := filepath.Abs(path); absPath == current {
+			continue
+		}
+		if fileInfo, err := entry.Info(); err != nil || (currentInfo != nil && os.SameFile(fileInfo, currentInfo)) {
+			continue
+		}
+		if rotate.Compress && strings.HasSuffix(name, ".log") {
+			if err := compressFile(path); err != nil {
+				fmt.Println("log compress failed, file:", path, ",err:", err)
+			} else {
+				path += ".gz"
+			}
+		}
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, oldLogFile{path: path, size: fileInfo.Size(), modTime: fileInfo.ModTime()})
+	}
+
+	// 从新到旧，超过保留天数或者累计大小超过限制的删除
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	var total int64
+	for _, file := range files {
+		total += file.size
+		expired := rotate.MaxAge > 0 && time.Since(file.modTime) > time.Duration(rotate.MaxAge)*24*time.Hour
+		if expired || (rotate.MaxTotal > 0 && total > rotate.MaxTotal) {
+			if err := os.Remove(file.path); err != nil {
+				fmt.Println("log remove failed, file:", file.path, ",err:", err)
+			}
+		}
+	}
+}
+
+// isRotatedName 是否是openFile生成的文件名：前缀+时间[_序号].log，压缩后再加.gz
+func isRotatedName(name, base, nameFormat string) bool {
+	if !strings.HasPrefix(name, base) {
+		return false
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, base), ".gz")
+	if !strings.HasSuffix(stamp, ".log") {
+		return false
+	}
+	stamp = strings.TrimSuffix(stamp, ".log")
+	if _, err := time.Parse(nameFormat, stamp); err == nil {
+		return true
+	}
+	// 同一时间已经有文件时加_序号
+	index := strings.LastIndexByte(stamp, '_')
+	if index < 0 {
+		return false
+	}
+	if _, err := strconv.Atoi(stamp[index+1:]); err != nil {
+		return false
+	}
+	_, err := time.Parse(nameFormat, stamp[:index])
+	return err == nil
+}
+
+// compressFile gzip压缩日志文件，完成后删除原文件，压缩后的文件保留原文件的修改时间
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fileInfo, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	zw.ModTime = fileInfo.ModTime()
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Chtimes(path+".gz", fileInfo.ModTime(), fileInfo.ModTime())
+	return os.Remove(path)
+}
@@ -0,0 +1,289 @@
+package log
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"pp/config"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 日志输出目标类型
+const (
+	SinkStdout = "stdout" // 标准输出，容器中运行时使用
+	SinkSyslog = "syslog" // 本机或者远程syslog
+	SinkUdp    = "udp"    // 每行日志一个udp包，发送到本机日志收集agent
+	SinkTcp    = "tcp"    // tcp按行发送，断开后自动重连
+)
+
+const (
+	sinkDialTimeout  = time.Second
+	sinkWriteTimeout = time.Second
+	sinkRetryDelay   = 5 * time.Second // tcp连接失败后多久重试
+	maxUdpLineSize   = 60 * 1024
+	tcpSinkQueueSize = 4096 // tcp等待发送的最大条数
+)
+
+// Sink 日志输出目标，日志文件之外可以同时输出到多个Sink
+// Write在写日志的协程中调用，不能长时间阻塞
+type Sink interface {
+	Name() string
+	Write(level int, line []byte) error
+	Close() error
+}
+
+type sinkEntry struct {
+	sink       Sink
+	level      int  // 只输出大于等于该级别的日志
+	format     int  // 输出格式
+	fromConfig bool // app.json配置的sink，配置变化时替换
+}
+
+// AddSink 添加自定义输出目标，level为输出的最低级别，不能低于全局级别
+func (al *AppLogger) AddSink(sink Sink, level int, format int) {
+	al.sinkMutex.Lock()
+	defer al.sinkMutex.Unlock()
+	sinks := append(al.loadSinks(), &sinkEntry{sink: sink, level: level, format: format})
+	al.sinks.Store(&sinks)
+}
+
+func (al *AppLogger) loadSinks() []*sinkEntry {
+	if sinks := al.sinks.Load(); sinks != nil {
+		return append([]*sinkEntry{}, (*sinks)...)
+	}
+	return nil
+}
+
+// applySinkConfig 按app.json重新创建配置的输出目标，关闭旧的输出目标，自定义输出目标保留
+func (al *AppLogger) applySinkConfig(sinkConfigs []config.LogSinkConfig, serverName string) {
+	al.sinkMutex.Lock()
+	defer al.sinkMutex.Unlock()
+	sinks := make([]*sinkEntry, 0, len(sinkConfigs))
+	var closeSinks []Sink
+	for _, entry := range al.loadSinks() {
+		if entry.fromConfig {
+			closeSinks = append(closeSinks, entry.sink)
+		} else {
+			sinks = append(sinks, entry)
+		}
+	}
+	for _, sinkConfig := range sinkConfigs {
+		sink, err := newSink(sinkConfig, serverName)
+		if err != nil {
+			fmt.Println("create log sink failed, type:", sinkConfig.Type, ",addr:", sinkConfig.Addr, ",err:", err)
+			continue
+		}
+		sinks = append(sinks, &sinkEntry{sink: sink, level: sinkConfig.Level, format: ParseFormat(sinkConfig.Format), fromConfig: true})
+	}
+	al.sinks.Store(&sinks)
+	for _, sink := range closeSinks {
+		_ = sink.Close()
+	}
+}
+
+func newSink(sinkConfig config.LogSinkConfig, serverName string) (Sink, error) {
+	switch sinkConfig.Type {
+	case SinkStdout:
+		return &stdoutSink{}, nil
+	case SinkSyslog:
+		tag := sinkConfig.Tag
+		if tag == "" {
+			tag = serverName
+		}
+		return newSyslogSink(sinkConfig.Addr, tag)
+	case SinkUdp:
+		return newUdpSink(sinkConfig.Addr)
+	case SinkTcp:
+		return newTcpSink(sinkConfig.Addr), nil
+	}
+	return nil, fmt.Errorf("unknown sink type %s", sinkConfig.Type)
+}
+
+// writeSinks 按每个输出目标的级别和格式输出
+func (al *AppLogger) writeSinks(rec *logRecord) {
+	sinks := al.sinks.Load()
+	if sinks == nil {
+		return
+	}
+	var lines [3][]byte
+	for _, entry := range *sinks {
+		if rec.level < entry.level {
+			continue
+		}
+		format := entry.format
+		if format < FormatText || format > FormatLogfmt {
+			format = FormatText
+		}
+		if lines[format] == nil {
+			lines[format] = formatLine(rec, format)
+		}
+		_ = entry.sink.Write(rec.level, lines[format])
+	}
+}
+
+// formatLine 输出到Sink的完整一行，文本格式包含时间和调用位置
+func formatLine(rec *logRecord, format int) []byte {
+	var line string
+	switch format {
+	case FormatJson:
+		line = formatJson(rec)
+	case FormatLogfmt:
+		line = formatLogfmt(rec)
+	default:
+		line = rec.time.Format("2006/01/02 15:04:05.000000") + " " + shortFile(rec.file) + ":" + fmt.Sprint(rec.line) + ": " + formatText(rec)
+	}
+	return []byte(line + "\n")
+}
+
+type stdoutSink struct {
+	mutex sync.Mutex
+}
+
+func (s *stdoutSink) Name() string {
+	return SinkStdout
+}
+
+func (s *stdoutSink) Write(level int, line []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err := os.Stdout.Write(line)
+	return err
+}
+
+func (s *stdoutSink) Close() error {
+	return nil
+}
+
+// udpSink 每行日志一个udp包，超长的日志截断
+type udpSink struct {
+	addr string
+	conn net.Conn
+}
+
+func newUdpSink(addr string) (*udpSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpSink{addr: addr, conn: conn}, nil
+}
+
+func (s *udpSink) Name() string {
+	return SinkUdp + "://" + s.addr
+}
+
+func (s *udpSink) Write(level int, line []byte) error {
+	if len(line) > maxUdpLineSize {
+		line = append(line[:maxUdpLineSize-1:maxUdpLineSize-1], '\n')
+	}
+	_, err := s.conn.Write(line)
+	return err
+}
+
+func (s *udpSink) Close() error {
+	return s.conn.Close()
+}
+
+// tcpSink 按行发送，连接和发送都在后台协程中，Write只放入有界队列
+// 没有连接或者队列满时丢弃日志，断开后间隔sinkRetryDelay重连，重连后发送一行丢弃的条数
+type tcpSink struct {
+	addr      string
+	queue     chan []byte   // 等待发送的日志，不会关闭，由stop通知后台协程退出
+	stop      chan struct{} // 关闭通知
+	stopOnce  sync.Once
+	done      chan struct{} // 后台协程已退出
+	connected int32         // 是否已连接，没有连接时Write直接丢弃
+	dropped   uint64        // 未发送成功的条数，重连后清零
+}
+
+func newTcpSink(addr string) *tcpSink {
+	s := &tcpSink{addr: addr, queue: make(chan []byte, tcpSinkQueueSize), stop: make(chan struct{}), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+func (s *tcpSink) Name() string {
+	return SinkTcp + "://" + s.addr
+}
+
+func (s *tcpSink) Write(level int, line []byte) error {
+	if atomic.LoadInt32(&s.connected) == 0 {
+		atomic.AddUint64(&s.dropped, 1)
+		return nil
+	}
+	select {
+	case s.queue <- line:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	return nil
+}
+
+// run 后台连接和发送，连接失败或者发送失败后等待sinkRetryDelay重连
+func (s *tcpSink) run() {
+	defer close(s.done)
+	for {
+		conn, err := net.DialTimeout("tcp", s.addr, sinkDialTimeout)
+		if err != nil {
+			fmt.Println("log sink connect failed,", s.Name(), ",err:", err)
+		} else {
+			s.send(conn)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(sinkRetryDelay):
+		}
+	}
+}
+
+// send 连接建立后发送队列中的日志，发送失败或者关闭时返回
+func (s *tcpSink) send(conn net.Conn) {
+	defer conn.Close()
+	if dropped := atomic.SwapUint64(&s.dropped, 0); dropped > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(sinkWriteTimeout))
+		_, _ = fmt.Fprintf(conn, "%s log sink reconnected, dropped lines: %d\n", time.Now().Format("2006/01/02 15:04:05.000000"), dropped)
+	}
+	atomic.StoreInt32(&s.connected, 1)
+	defer atomic.StoreInt32(&s.connected, 0)
+	for {
+		select {
+		case line := <-s.queue:
+			_ = conn.SetWriteDeadline(time.Now().Add(sinkWriteTimeout))
+			if _, err := conn.Write(line); err != nil {
+				atomic.AddUint64(&s.dropped, 1)
+				fmt.Println("log sink write failed,", s.Name(), ",err:", err)
+				return
+			}
+		case <-s.stop:
+			// 关闭前尽量发送已经在队列中的日志
+			_ = conn.SetWriteDeadline(time.Now().Add(sinkWriteTimeout))
+			for len(s.queue) > 0 {
+				if _, err := conn.Write(<-s.queue); err != nil {
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+func (s *tcpSink) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+	return nil
+}
+
+// sinkNames 所有输出目标名称，用于打印
+func (al *AppLogger) sinkNames() string {
+	names := make([]string, 0)
+	for _, entry := range al.loadSinks() {
+		names = append(names, entry.sink.Name())
+	}
+	return strings.Join(names, ",")
+}
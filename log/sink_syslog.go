@@ -0,0 +1,53 @@
+//go:build !windows && !plan9
+
+package log
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// syslogSink 按日志级别对应syslog级别，地址为空时连接本机syslog，否则使用udp发送到远程syslog
+type syslogSink struct {
+	addr   string
+	writer *syslog.Writer
+}
+
+func newSyslogSink(addr, tag string) (Sink, error) {
+	network := ""
+	if addr != "" {
+		network = "udp"
+	}
+	writer, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{addr: addr, writer: writer}, nil
+}
+
+func (s *syslogSink) Name() string {
+	if s.addr == "" {
+		return SinkSyslog
+	}
+	return SinkSyslog + "://" + s.addr
+}
+
+func (s *syslogSink) Write(level int, line []byte) error {
+	msg := strings.TrimSuffix(string(line), "\n")
+	switch level {
+	case LogDebug:
+		return s.writer.Debug(msg)
+	case LogInfo:
+		return s.writer.Info(msg)
+	case LogWarn:
+		return s.writer.Warning(msg)
+	case LogError:
+		return s.writer.Err(msg)
+	default:
+		return s.writer.Crit(msg)
+	}
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package log
+
+import "errors"
+
+func newSyslogSink(addr, tag string) (Sink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
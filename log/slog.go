@@ -0,0 +1,140 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// 日志级别和slog级别对应关系，LogFatal对应slog.LevelError+4
+func toSlogLevel(level int) slog.Level {
+	switch level {
+	case LogDebug:
+		return slog.LevelDebug
+	case LogInfo:
+		return slog.LevelInfo
+	case LogWarn:
+		return slog.LevelWarn
+	case LogError:
+		return slog.LevelError
+	default:
+		return slog.LevelError + 4
+	}
+}
+
+func fromSlogLevel(level slog.Level) int {
+	switch {
+	case level < slog.LevelInfo:
+		return LogDebug
+	case level < slog.LevelWarn:
+		return LogInfo
+	case level < slog.LevelError:
+		return LogWarn
+	case level < slog.LevelError+4:
+		return LogError
+	default:
+		return LogFatal
+	}
+}
+
+type slogHandlerHolder struct {
+	handler slog.Handler
+}
+
+func (h *slogHandlerHolder) handle(rec *logRecord) {
+	level := toSlogLevel(rec.level)
+	if !h.handler.Enabled(context.Background(), level) {
+		return
+	}
+	record := slog.NewRecord(rec.time, level, rec.msg, rec.pc)
+	if rec.module != "" {
+		record.AddAttrs(slog.String("module", rec.module))
+	}
+	record.Add(rec.fields...)
+	_ = h.handler.Handle(context.Background(), record)
+}
+
+// SetSlogHandler 日志改为输出到slog.Handler，例如slog.NewJSONHandler(os.Stdout, nil)，为nil时恢复输出到文件
+// 级别过滤仍然先按AppLogger的级别
+func (al *AppLogger) SetSlogHandler(handler slog.Handler) {
+	if handler == nil {
+		al.slogHandler.Store(nil)
+		return
+	}
+	// 避免输出到自身导致死循环
+	if bridge, ok := handler.(*slogBridge); ok && bridge.logger == al {
+		return
+	}
+	al.slogHandler.Store(&slogHandlerHolder{handler: handler})
+}
+
+// SlogHandler 返回写入AppLogger的slog.Handler，使用slog.New(logger.SlogHandler())的代码也输出到日志文件
+func (al *AppLogger) SlogHandler() slog.Handler {
+	return &slogBridge{logger: al}
+}
+
+// slogBridge slog.Handler写入AppLogger
+type slogBridge struct {
+	logger *AppLogger
+	fields []interface{}
+	group  string
+}
+
+func (b *slogBridge) Enabled(ctx context.Context, level slog.Level) bool {
+	return b.logger.enabled(fromSlogLevel(level))
+}
+
+func (b *slogBridge) Handle(ctx context.Context, record slog.Record) error {
+	rec := &logRecord{time: record.Time, level: fromSlogLevel(record.Level), pc: record.PC, msg: record.Message}
+	if rec.time.IsZero() {
+		rec.time = time.Now()
+	}
+	rec.fields = make([]interface{}, 0, len(b.fields)+record.NumAttrs()*2)
+	rec.fields = append(rec.fields, b.fields...)
+	record.Attrs(func(attr slog.Attr) bool {
+		rec.fields = appendAttr(rec.fields, b.group, attr)
+		return true
+	})
+	b.logger.output(rec)
+	return nil
+}
+
+func (b *slogBridge) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := append([]interface{}{}, b.fields...)
+	for _, attr := range attrs {
+		fields = appendAttr(fields, b.group, attr)
+	}
+	return &slogBridge{logger: b.logger, fields: fields, group: b.group}
+}
+
+func (b *slogBridge) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return b
+	}
+	group := name
+	if b.group != "" {
+		group = b.group + "." + name
+	}
+	return &slogBridge{logger: b.logger, fields: b.fields, group: group}
+}
+
+// appendAttr 分组的key用.连接
+func appendAttr(fields []interface{}, group string, attr slog.Attr) []interface{} {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+	key := attr.Key
+	if group != "" && key != "" {
+		key = group + "." + key
+	} else if key == "" {
+		key = group
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, child := range attr.Value.Group() {
+			fields = appendAttr(fields, key, child)
+		}
+		return fields
+	}
+	return append(fields, key, attr.Value.Any())
+}
@@ -0,0 +1,58 @@
+package log
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 玩家调试跟踪，开启后带有该玩家ID的日志(WithUser)不受日志级别限制，到期后自动关闭
+
+// TraceUser 开启玩家调试跟踪，duration后自动关闭，重复开启时更新到期时间
+func (al *AppLogger) TraceUser(userID int, duration time.Duration) time.Time {
+	expire := time.Now().Add(duration)
+	if _, loaded := al.traceUsers.Swap(userID, expire); !loaded {
+		atomic.AddInt32(&al.traceCount, 1)
+	}
+	time.AfterFunc(duration, func() {
+		if al.traceUsers.CompareAndDelete(userID, expire) {
+			atomic.AddInt32(&al.traceCount, -1)
+			al.Info("user trace expired, userID:", userID)
+		}
+	})
+	al.Info("user trace start, userID:", userID, ",expire:", expire.Format(time.DateTime))
+	return expire
+}
+
+// UntraceUser 关闭玩家调试跟踪，没有开启时返回false
+func (al *AppLogger) UntraceUser(userID int) bool {
+	if _, loaded := al.traceUsers.LoadAndDelete(userID); loaded {
+		atomic.AddInt32(&al.traceCount, -1)
+		al.Info("user trace stop, userID:", userID)
+		return true
+	}
+	return false
+}
+
+// TracedUsers 正在调试跟踪的玩家和到期时间
+func (al *AppLogger) TracedUsers() map[int]time.Time {
+	users := make(map[int]time.Time)
+	al.traceUsers.Range(func(key, value interface{}) bool {
+		users[key.(int)] = value.(time.Time)
+		return true
+	})
+	return users
+}
+
+// isUserTraced 玩家是否正在调试跟踪，没有跟踪任何玩家时不查表
+func (al *AppLogger) isUserTraced(userID int) bool {
+	if atomic.LoadInt32(&al.traceCount) == 0 {
+		return false
+	}
+	expire, ok := al.traceUsers.Load(userID)
+	return ok && time.Now().Before(expire.(time.Time))
+}
+
+// WithUser 返回带有玩家ID的日志，玩家开启调试跟踪时输出所有级别的日志
+func (al *AppLogger) WithUser(userID int) *Entry {
+	return &Entry{logger: al, fields: []interface{}{"userID", userID}, userID: userID, traceUser: true}
+}
@@ -1,41 +1,59 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
-	"net/http"
-	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"pp/config"
 	"pp/log"
 	"pp/service"
-	"runtime"
+	"pp/service/monitor"
 	"syscall"
-	"time"
 )
 
-func runPProfServer() {
-	_ = http.ListenAndServe("0.0.0.0:6061", nil)
-}
+var (
+	configPath  = flag.String("config", "./app.json", "app.json配置文件路径")
+	configEnv   = flag.String("env", "", "环境名，存在app.<env>.json时覆盖基础配置，默认读取环境变量PP_ENV")
+	printConfig = flag.Bool("print-config", false, "打印合并后的最终配置(隐藏密码)后退出")
+	encrypt     = flag.String("encrypt-secret", "", "用PP_CONFIG_KEY加密密码，输出可以写到app.json中的ENC(...)后退出")
+)
 
 func main() {
-	go runPProfServer()
+	flag.Parse()
+	if *encrypt != "" {
+		secret, err := config.EncryptSecret(*encrypt)
+		if err != nil {
+			fmt.Println("encrypt secret error,", err)
+			os.Exit(1)
+		}
+		fmt.Println(secret)
+		return
+	}
 	// 加载app.json需要第一时间启动
 	appConfigLoad := config.NewAppConfig()
-	appConfigLoad.LoadConfig()
-
+	appConfigLoad.SetPath(*configPath, *configEnv)
+	if !appConfigLoad.LoadConfig() {
+		fmt.Println("load app config error")
+		os.Exit(1)
+	}
+	if *printConfig {
+		data, _ := json.MarshalIndent(appConfigLoad.GetSnapshot().Redacted(), "", "  ")
+		fmt.Println(string(data))
+		return
+	}
 	// 初始化日志系统
 	logger := log.GetLogger()
 	if !logger.InitLogger() {
 		fmt.Println("init logger error")
-		return
+		os.Exit(1)
 	}
 
-	// 服务器启动相关初始化
+	// 服务器启动相关初始化，失败时执行退出处理后以非0状态退出
 	svrLibHandler := service.GetSvrlibhandler()
 	if !svrLibHandler.OnInit() {
-		logger.Error("svrLibHandler.OnInit failed")
-		return
+		logger.Fatal("svrLibHandler.OnInit failed")
 	}
 
 	// 启动玩家消息处理
@@ -44,25 +62,33 @@ func main() {
 	appConfig := appConfigLoad.GetConfig()
 	logger.Info("Start server:" + appConfig.ServerName)
 
-	go func() {
-		for {
-			logger.Info("current Goroutine count:", runtime.NumGoroutine())
-			time.Sleep(time.Second)
-		}
-	}()
-
 	c := make(chan os.Signal, 1) // ---> 优雅重启
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	for sig := range captureSignals {
+		signal.Notify(c, sig)
+	}
 	for {
 		s := <-c
 		logger.Info("get a signal ", s.String())
+		if kind, ok := captureSignals[s]; ok {
+			// 采集期间继续处理其他信号
+			if path, err := monitor.GetMonitor().Capture(kind, 0); err != nil {
+				logger.Error("capture by signal failed, type:", kind, ",err:", err)
+			} else {
+				logger.Info("capture by signal, type:", kind, ",path:", path)
+			}
+			continue
+		}
 		switch s {
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
 			svrLibHandler.OnQuit()
 			return
 		case syscall.SIGHUP:
-			svrLibHandler.ReloadAppConfig()
-			logger.Info("reload app.json success")
+			if svrLibHandler.ReloadAppConfig() {
+				logger.Info("reload app.json success, version:", appConfigLoad.GetVersion())
+			} else {
+				logger.Error("reload app.json failed, keep version:", appConfigLoad.GetVersion())
+			}
 		default:
 			return
 		}
@@ -8,6 +8,7 @@ import (
 	"pp/log"
 	"pp/network/base"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,7 +16,7 @@ var (
 	maxMsgLen uint32 = 100 * 1024 * 1024
 	minMsgLen uint32 = 8
 	lenMsgLen        = 4
-	logger           = log.GetLogger()
+	logger           = log.GetLogger().Module("network")
 )
 
 func GetConnect(addr string) (*NetClient, error) {
@@ -28,7 +29,16 @@ func GetConnect(addr string) (*NetClient, error) {
 }
 
 type NetClient struct {
-	Conn *net.Conn
+	Conn  *net.Conn
+	Stats *NetStats // 收发统计，为空时不统计
+}
+
+// NetStats 连接收发统计，可以在多次重连之间共用，所有字段原子读写
+type NetStats struct {
+	BytesIn  uint64
+	BytesOut uint64
+	MsgIn    uint64
+	MsgOut   uint64
 }
 
 func (this *NetClient) Close() {
@@ -38,11 +48,17 @@ func (this *NetClient) Close() {
 }
 
 func (this *NetClient) SendMsg(msgID uint32, data []byte) {
-	_, err := (*this.Conn).Write(this.PackMsg(msgID, data))
+	n, err := (*this.Conn).Write(this.PackMsg(msgID, data))
+	if this.Stats != nil {
+		atomic.AddUint64(&this.Stats.BytesOut, uint64(n))
+	}
 	if err != nil {
 		logger.Error("send msg failed,", msgID, data, err.Error())
 		return
 	}
+	if this.Stats != nil {
+		atomic.AddUint64(&this.Stats.MsgOut, 1)
+	}
 }
 
 func (this *NetClient) PackMsg(msgID uint32, data []byte) []byte {
@@ -113,6 +129,10 @@ func (this *NetClient) ReadOnePacketMsg(conn net.Conn) ([]byte, error, uint32) {
 	if _, err := io.ReadFull(conn, msgData); err != nil {
 		return nil, err, 0
 	}
+	if this.Stats != nil {
+		atomic.AddUint64(&this.Stats.BytesIn, uint64(msgLen)+4)
+		atomic.AddUint64(&this.Stats.MsgIn, 1)
+	}
 
 	return msgData, nil, msgId
 }
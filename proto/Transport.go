@@ -10,6 +10,8 @@ type ClientToServerMsgProto struct {
 	ServerType int    // 服务器类型
 	MsgID      uint32 // 消息类型
 	Data       string // 具体协议内容
+	TraceID    string `json:"traceid,omitempty"` // 调用链ID，网关生成或者透传
+	SpanID     string `json:"spanid,omitempty"`  // 发送方的处理步骤ID
 }
 
 // ServerToClientMsg 消息发送给客户端
@@ -37,12 +39,14 @@ type ServerToGrpcMsg struct {
 
 // ServerToServerMsg  Server --- > Server 转发单个服务器
 type ServerToServerMsg struct {
-	TargetServerID   int    `json:"targetserverid"`   // 服务器的ServerID
-	TargetServerType int    `json:"targetservertype"` // 服务器类型
-	ServerID         int    `json:"serverid"`         // 发送者的ServerID
-	ServerType       int    `json:"servertype"`       // 发送者的ServerType
-	MsgID            uint32 `json:"msgid"`            // 消息ID
-	Data             string `json:"data"`             // 数据封装
+	TargetServerID   int    `json:"targetserverid"`    // 服务器的ServerID
+	TargetServerType int    `json:"targetservertype"`  // 服务器类型
+	ServerID         int    `json:"serverid"`          // 发送者的ServerID
+	ServerType       int    `json:"servertype"`        // 发送者的ServerType
+	MsgID            uint32 `json:"msgid"`             // 消息ID
+	Data             string `json:"data"`              // 数据封装
+	TraceID          string `json:"traceid,omitempty"` // 调用链ID
+	SpanID           string `json:"spanid,omitempty"`  // 发送方的处理步骤ID
 }
 
 // ServerToAllServerMsg server ----> allServer,转发给所有服务器类型等于serverType的服务器
@@ -69,8 +73,30 @@ type NotifyServerState struct {
 }
 
 // NotifyServerConfigUpdate ProtoServerLoadConfig = 11014 //通知各个游戏服务器加载配置
+// 回复加载结果也使用ProtoServerLoadConfig，IsResp为1，ServerID和ServerType为回复者
 type NotifyServerConfigUpdate struct {
-	UpdateKey []string `json:"updatekey"` // 更新配置的key
+	UpdateKey  []string           `json:"updatekey"`         // 更新配置的key
+	RequestID  string             `json:"requestid"`         // 请求ID，回复时原样带回
+	ServerID   int                `json:"serverid"`          // 发送者的ServerID，为0时不回复
+	ServerType int                `json:"servertype"`        // 发送者的ServerType
+	IsResp     int                `json:"isresp,omitempty"`  // 1：加载结果回复
+	Results    []ConfigLoadResult `json:"results,omitempty"` // 回复时带回各配置加载结果
+}
+
+// ConfigLoadResult 单个配置加载结果
+type ConfigLoadResult struct {
+	Key     string `json:"key"`     // 配置的key
+	Success bool   `json:"success"` // 是否加载成功
+	Version int64  `json:"version"` // 当前生效的配置版本
+	Error   string `json:"error"`   // 失败原因
+}
+
+// ServerConfigUpdateResult 单个服务器加载配置的结果，由ProtoServerLoadConfig的回复生成
+type ServerConfigUpdateResult struct {
+	RequestID  string             `json:"requestid"`  // 请求ID
+	ServerID   int                `json:"serverid"`   // 回复者的ServerID
+	ServerType int                `json:"servertype"` // 回复者的ServerType
+	Results    []ConfigLoadResult `json:"results"`    // 各配置加载结果
 }
 
 // NotifyStopTargetServer 后台指定服务器停服 ProtoStopTargetServer = 11017
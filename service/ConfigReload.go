@@ -0,0 +1,181 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pp/config"
+	"pp/proto"
+	"pp/service/admin"
+	"pp/service/cluster"
+	serviceConfig "pp/service/config"
+	gate "pp/service/conn"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 集群加载配置的默认和最长等待时间
+const (
+	defaultReloadTimeout = 5 * time.Second
+	maxReloadTimeout     = time.Minute
+)
+
+var (
+	reloadWaitMap   = make(map[string]chan proto.ServerConfigUpdateResult) // 等待回复的加载配置请求，key为RequestID
+	reloadWaitMutex sync.Mutex
+)
+
+func init() {
+	admin.HandleFunc("/config/reload", configReloadHandler)
+}
+
+// ServerLoadConfigHandler 加载配置 ProtoServerLoadConfig，加载完成后把各配置结果回复给发送者
+// 其他服务器回复的加载结果也是ProtoServerLoadConfig，IsResp为1
+func ServerLoadConfigHandler(conn *gate.GateClient, userID int, msgID uint32, data []byte) {
+	var msg proto.NotifyServerConfigUpdate
+	if err := json.Unmarshal(data, &msg); err != nil {
+		logger.Error("ServerLoadConfigHandler data format error,", err.Error(), ",data:", string(data))
+		return
+	}
+	if msg.IsResp == 1 {
+		onServerLoadConfigResp(msg)
+		return
+	}
+	results := serviceConfig.NewAppConfigMgr().ReloadConfigWithResult(msg.UpdateKey)
+	logger.Info("ServerLoadConfigHandler, requestID:", msg.RequestID, ",serverID:", msg.ServerID, ",results:", results)
+	if msg.ServerID == 0 {
+		return
+	}
+
+	appConfig := config.NewAppConfig().GetConfig()
+	resp := proto.NotifyServerConfigUpdate{RequestID: msg.RequestID, ServerID: appConfig.ServerID, ServerType: appConfig.ServerType, IsResp: 1, Results: results}
+	respData, err := json.Marshal(&resp)
+	if err != nil {
+		logger.Error("ServerLoadConfigHandler resp format error,", err.Error())
+		return
+	}
+	conn.SendMsgToServer(msg.ServerID, msg.ServerType, proto.ProtoServerLoadConfig, respData)
+}
+
+// onServerLoadConfigResp 其他服务器回复加载配置结果，交给等待中的ReloadClusterConfig
+func onServerLoadConfigResp(msg proto.NotifyServerConfigUpdate) {
+	logger.Info("ServerLoadConfigHandler resp, requestID:", msg.RequestID, ",serverType:", msg.ServerType, ",serverID:", msg.ServerID, ",results:", msg.Results)
+
+	reloadWaitMutex.Lock()
+	waitChan, ok := reloadWaitMap[msg.RequestID]
+	reloadWaitMutex.Unlock()
+	if !ok {
+		return
+	}
+	result := proto.ServerConfigUpdateResult{RequestID: msg.RequestID, ServerID: msg.ServerID, ServerType: msg.ServerType, Results: msg.Results}
+	select {
+	case waitChan <- result:
+	default:
+		logger.Warn("ServerLoadConfigHandler wait chan full, requestID:", msg.RequestID)
+	}
+}
+
+// ReloadClusterConfig 通知所有类型为serverType的运行中服务器重新加载配置，等待回复直到timeout
+// 目标服务器来自集群视图，通过网关逐个转发；本服务器也是该类型时直接在本地加载
+func ReloadClusterConfig(serverType int, keys []string, timeout time.Duration) ([]proto.ServerConfigUpdateResult, error) {
+	appConfig := config.NewAppConfig().GetConfig()
+	results := make([]proto.ServerConfigUpdateResult, 0)
+	if serverType == appConfig.ServerType {
+		results = append(results, proto.ServerConfigUpdateResult{
+			ServerID:   appConfig.ServerID,
+			ServerType: appConfig.ServerType,
+			Results:    serviceConfig.NewAppConfigMgr().ReloadConfigWithResult(keys),
+		})
+	}
+	targets := make([]int, 0)
+	for _, info := range cluster.GetClusterMgr().GetServersByType(serverType) {
+		if info.State == cluster.ServerStateRunning {
+			targets = append(targets, info.ServerID)
+		}
+	}
+	if len(targets) == 0 {
+		if len(results) > 0 {
+			return results, nil
+		}
+		return nil, fmt.Errorf("no running server of type %d", serverType)
+	}
+	client, ok := gate.GetGateClientMgr().RandOneClient()
+	if !ok {
+		return results, errors.New("no gate connected")
+	}
+	msg := proto.NotifyServerConfigUpdate{
+		UpdateKey:  keys,
+		RequestID:  fmt.Sprintf("%d-%d", appConfig.ServerID, time.Now().UnixNano()),
+		ServerID:   appConfig.ServerID,
+		ServerType: appConfig.ServerType,
+	}
+	data, err := json.Marshal(&msg)
+	if err != nil {
+		return results, err
+	}
+
+	waitChan := make(chan proto.ServerConfigUpdateResult, len(targets))
+	reloadWaitMutex.Lock()
+	reloadWaitMap[msg.RequestID] = waitChan
+	reloadWaitMutex.Unlock()
+	defer func() {
+		reloadWaitMutex.Lock()
+		delete(reloadWaitMap, msg.RequestID)
+		reloadWaitMutex.Unlock()
+	}()
+
+	for _, serverID := range targets {
+		client.SendMsgToServer(serverID, serverType, proto.ProtoServerLoadConfig, data)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for received := 0; received < len(targets); received++ {
+		select {
+		case result := <-waitChan:
+			results = append(results, result)
+		case <-timer.C:
+			return results, fmt.Errorf("wait reload result timeout, received %d/%d", received, len(targets))
+		}
+	}
+	return results, nil
+}
+
+// configReloadHandler 集群加载配置
+//
+//	POST /config/reload?type=4&keys=a,b&timeout=5  通知所有类型为4的服务器重新加载配置a和b，最多等待5秒
+func configReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		admin.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	serverType, err := strconv.Atoi(r.FormValue("type"))
+	if err != nil || serverType <= 0 {
+		admin.WriteError(w, http.StatusBadRequest, "type must be a positive server type")
+		return
+	}
+	keys := strings.Split(r.FormValue("keys"), ",")
+	if r.FormValue("keys") == "" {
+		admin.WriteError(w, http.StatusBadRequest, "keys is required")
+		return
+	}
+	timeout := defaultReloadTimeout
+	if value := r.FormValue("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxReloadTimeout {
+			admin.WriteError(w, http.StatusBadRequest, "timeout must be 1-60 seconds")
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	logger.Info("admin reload cluster config, type:", serverType, ",keys:", keys, ",remote:", r.RemoteAddr)
+	results, err := ReloadClusterConfig(serverType, keys, timeout)
+	resp := map[string]interface{}{"results": results}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	admin.WriteJson(w, resp)
+}
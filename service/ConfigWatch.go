@@ -0,0 +1,103 @@
+package service
+
+import (
+	"path/filepath"
+	"pp/common/watcher"
+	"pp/config"
+	serviceConfig "pp/service/config"
+	"sync"
+	"time"
+)
+
+var (
+	configWatcher      *watcher.Watcher
+	configWatcherMutex sync.Mutex // 保护configWatcher
+	configReloadMutex  sync.Mutex // 防抖后的多次回调可能同时执行，保证同一时间只有一次重新加载
+)
+
+// startConfigWatcher 监听app.json和配置表目录，文件变化后只重新加载变化的配置
+func startConfigWatcher() bool {
+	configWatcherMutex.Lock()
+	defer configWatcherMutex.Unlock()
+	if configWatcher != nil {
+		return true
+	}
+	appConfig := config.NewAppConfig().GetConfig()
+	w, err := watcher.NewWatcher(time.Duration(appConfig.WatchConfig.Debounce)*time.Millisecond, onConfigFileChange)
+	if err != nil {
+		logger.Error("startConfigWatcher failed,", err.Error())
+		return false
+	}
+	for _, file := range config.NewAppConfig().Files() {
+		if err := w.AddFile(file); err != nil {
+			logger.Error("startConfigWatcher add file failed, file:", file, ",err:", err.Error())
+			w.Close()
+			return false
+		}
+	}
+	if err := w.AddDir(appConfig.ConfigDir); err != nil {
+		logger.Warn("startConfigWatcher add config dir failed, dir:", appConfig.ConfigDir, ",err:", err.Error())
+	}
+	configWatcher = w
+	logger.Info("startConfigWatcher success, files:", config.NewAppConfig().Files(), ",dir:", appConfig.ConfigDir)
+	return true
+}
+
+// stopConfigWatcher 停止监听
+func stopConfigWatcher() {
+	configWatcherMutex.Lock()
+	defer configWatcherMutex.Unlock()
+	if configWatcher != nil {
+		configWatcher.Close()
+		configWatcher = nil
+		logger.Info("stopConfigWatcher success")
+	}
+}
+
+// onWatchConfigChange watch配置变化时开启、关闭或者按新的防抖时间和配置表目录重新监听
+func onWatchConfigChange(old, new *config.AppConfigInfo) {
+	if old.WatchConfig == new.WatchConfig && old.ConfigDir == new.ConfigDir {
+		return
+	}
+	stopConfigWatcher()
+	if new.WatchConfig.Enable {
+		startConfigWatcher()
+	}
+}
+
+// onConfigFileChange 配置文件变化回调
+func onConfigFileChange(files []string) {
+	configReloadMutex.Lock()
+	defer configReloadMutex.Unlock()
+	logger.Info("config file change, files:", files)
+	changed := make(map[string]bool, len(files))
+	for _, file := range files {
+		changed[file] = true
+	}
+	for _, file := range config.NewAppConfig().Files() {
+		absPath, err := filepath.Abs(file)
+		if err != nil || !changed[absPath] {
+			continue
+		}
+		if GetSvrlibhandler().ReloadAppConfig() {
+			logger.Info("watch reload app config success, version:", config.NewAppConfig().GetVersion())
+		} else {
+			logger.Error("watch reload app config failed, keep version:", config.NewAppConfig().GetVersion())
+		}
+		break
+	}
+
+	results := serviceConfig.NewAppConfigMgr().ReloadConfigByFiles(files)
+	if len(results) > 0 {
+		logger.Info("watch reload config, results:", results)
+	}
+}
+
+// onAppConfigDiff 打印app.json变化的字段
+func onAppConfigDiff(old, new *config.AppConfigInfo) {
+	diff := config.DiffConfig(old, new)
+	logger.Info("app config change, version:", old.Version, "->", new.Version, ",diff count:", len(diff))
+	for _, line := range diff {
+		logger.Info("app config diff, ", line)
+	}
+}
@@ -1,21 +1,45 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"pp/common/metrics"
+	"pp/common/tracing"
+	"pp/log"
 	gate "pp/service/conn"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
 	"time"
-	"unsafe"
+)
+
+var (
+	draining      int32 // 是否处于停服排空阶段
+	inflightCount int32 // 正在处理中的消息数
+	rejectCount   int32 // 停服排空阶段拒绝的消息数
+
+	dispatchLogger = log.GetLogger().Module("dispatch")
+
+	handlerDuration = metrics.NewHistogramVec("pp_handler_duration_seconds", "消息处理耗时(秒)", nil, "msgid")
+	handlerErrors   = metrics.NewCounterVec("pp_handler_errors_total", "消息处理错误数 reason：notfound rejected panic", "msgid", "reason")
 )
 
 // StartMessageProcess 先在一个协程中处理，，消息处理在开个协程单独处理
 func StartMessageProcess() {
 	// 消息链接管理
 	// 收到玩家建立链接断开链接和消息处理
-	logger.Info("start conn message and close message process")
+	dispatchLogger.Info("start conn message and close message process")
 	for {
 		select {
 		case msg := <-gate.MessageDataChan:
-			logger.Debug("handler msg start, msgID:", msg.Data.MsgID)
-			go ProcessOneMessage(msg.Client, msg.Data.MsgID, msg.Data.Data)
+			dispatchLogger.Debug("handler msg start, msgID:", msg.Data.MsgID)
+			// 先计数再启动协程，保证停服时不会漏掉已取出但未开始处理的消息
+			atomic.AddInt32(&inflightCount, 1)
+			go func() {
+				defer atomic.AddInt32(&inflightCount, -1)
+				ProcessOneMessage(msg.Client, msg.Data.MsgID, msg.Data.Data)
+			}()
 		}
 	}
 }
@@ -33,11 +57,62 @@ func ProcessOneMessage(conn *gate.GateClient, msgID uint32, data []byte) {
 	msgMgr := GetMsgHandlerMgr()
 	handler, ok := msgMgr.GetMsgHandler(handlerMsgID)
 	if !ok {
-		logger.Debug("handler msg can not find, serverID:", conn.ServerID, ",userID:", userID, ",msgID:", handlerMsgID, ",data:", *(*string)(unsafe.Pointer(&handlerData)))
+		handlerErrors.With("unknown", "notfound").Inc() // 未注册的msgID来自外部，不作为label，避免指标数量无限增长
+		dispatchLogger.With("serverID", conn.ServerID).Debug("handler msg can not find", "msgID", handlerMsgID, "data", string(handlerData))
 		return
 	}
-	// 调用函数处理
-	now := time.Now().UnixNano()
-	handler(conn, userID, handlerMsgID, handlerData)
-	logger.Info("handler msg, serverID:", conn.ServerID, ",userID:", userID, ",duration:", time.Now().UnixNano()-now, ",msgID:", handlerMsgID, ",data:", *(*string)(unsafe.Pointer(&handlerData)))
+	// 停服排空阶段不再接收新的业务消息，只处理服务器内部消息
+	if IsDraining() && !msgMgr.IsInnerMsg(handlerMsgID) {
+		atomic.AddInt32(&rejectCount, 1)
+		handlerErrors.With(strconv.FormatUint(uint64(handlerMsgID), 10), "rejected").Inc()
+		dispatchLogger.With("serverID", conn.ServerID).Warn("handler msg rejected while draining", "msgID", handlerMsgID, "data", string(handlerData))
+		return
+	}
+	// 消息中带有其他服务器的traceID时加入同一个调用链
+	msgIDLabel := strconv.FormatUint(uint64(handlerMsgID), 10)
+	traceID, parentSpanID := traceFromData(handlerData)
+	ctx, span := tracing.StartSpan(tracing.ContextWithRemote(context.Background(), traceID, parentSpanID), "handle msg "+msgIDLabel)
+	span.SetAttr("msgid", handlerMsgID)
+	span.SetAttr("gateid", conn.ServerID)
+
+	// 调用函数处理，单个消息处理panic不影响其他消息
+	// recover和span.End在同一个defer中，保证panic先记录到span再结束导出
+	now := time.Now()
+	defer func() {
+		if err := recover(); err != nil {
+			handlerErrors.With(msgIDLabel, "panic").Inc()
+			span.SetError(err)
+			dispatchLogger.Ctx(ctx).Error("handler msg panic", "serverID", conn.ServerID, "msgID", handlerMsgID, "err", err, "stack", string(debug.Stack()))
+		}
+		span.End()
+	}()
+	handler(ctx, conn, userID, handlerMsgID, handlerData)
+	duration := time.Since(now)
+	handlerDuration.With(msgIDLabel).Observe(duration.Seconds())
+	dispatchLogger.Ctx(ctx).Debug("handler msg", "serverID", conn.ServerID, "duration", duration.Nanoseconds(), "msgID", handlerMsgID, "data", string(handlerData))
+}
+
+// traceFromData 消息中带有traceid时取出，没有时不解析整个消息
+func traceFromData(data []byte) (traceID, spanID string) {
+	if !bytes.Contains(data, []byte(`"traceid"`)) {
+		return "", ""
+	}
+	var msg struct {
+		TraceID string `json:"traceid"`
+		SpanID  string `json:"spanid"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return "", ""
+	}
+	return msg.TraceID, msg.SpanID
+}
+
+// IsDraining 是否处于停服排空阶段
+func IsDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// InflightCount 正在处理中的消息数
+func InflightCount() int {
+	return int(atomic.LoadInt32(&inflightCount))
 }
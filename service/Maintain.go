@@ -0,0 +1,266 @@
+package service
+
+import (
+	"encoding/json"
+	"pp/config"
+	"pp/proto"
+	gate "pp/service/conn"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MaintainPolicyReject = "reject" // 维护期间拒绝新进入
+	MaintainPolicyQueue  = "queue"  // 维护期间排队，开服后继续进入
+
+	defaultMaintainQueueMax = 1000
+	maintainPlayerExpire    = 24 * time.Hour // 进入玩法后超过这个时间没有再进入的玩家不再记录，没有收到离开消息时避免一直增长
+)
+
+var (
+	maintainMgr     *MaintainMgr
+	maintainMgrOnce sync.Once
+)
+
+func GetMaintainMgr() *MaintainMgr {
+	maintainMgrOnce.Do(func() {
+		if maintainMgr == nil {
+			maintainMgr = &MaintainMgr{gameStop: make(map[int]bool), waitQueue: make(map[int][]func()), players: make(map[int]map[int]int64)}
+		}
+	})
+	return maintainMgr
+}
+
+// MaintainMgr 维护状态管理，由后台停服/停指定服务器消息驱动，开服不需要重启进程
+type MaintainMgr struct {
+	mutex      sync.RWMutex
+	serverStop bool                  // 整个服务器维护中
+	gameStop   map[int]bool          // 指定玩法维护中，key为GameID
+	waitQueue  map[int][]func()      // 维护期间排队的进入请求，key为GameID
+	players    map[int]map[int]int64 // 通过TryEnter进入玩法的玩家和进入时间，key为GameID，停指定玩法时只通知这些玩家
+}
+
+// MaintainState 维护状态
+type MaintainState struct {
+	ServerStop bool        `json:"serverstop"` // 整个服务器维护中
+	GameStop   []int       `json:"gamestop"`   // 维护中的玩法
+	WaitCount  map[int]int `json:"waitcount"`  // 各玩法排队数量
+}
+
+// IsServerMaintain 整个服务器是否维护中
+func (m *MaintainMgr) IsServerMaintain() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.serverStop
+}
+
+// IsMaintain 玩法是否维护中，整个服务器维护时所有玩法都维护中
+func (m *MaintainMgr) IsMaintain(gameID int) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.serverStop || m.gameStop[gameID]
+}
+
+// GetState 获取当前维护状态
+func (m *MaintainMgr) GetState() MaintainState {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	state := MaintainState{ServerStop: m.serverStop, GameStop: make([]int, 0), WaitCount: make(map[int]int)}
+	for gameID, stop := range m.gameStop {
+		if stop {
+			state.GameStop = append(state.GameStop, gameID)
+		}
+	}
+	for gameID, queue := range m.waitQueue {
+		state.WaitCount[gameID] = len(queue)
+	}
+	return state
+}
+
+// TryEnter 玩家进入玩法，未维护时直接执行enter(false)并返回true
+// 维护中按maintain.policy配置拒绝或者排队，排队的在开服后执行enter(true)，两种情况都会通知玩家并返回false
+// 进入玩法的消息通过MsgHandlerMgr.RegisterEnterMsgHandlerFunc注册时自动调用
+func (m *MaintainMgr) TryEnter(userID, gameID int, enter func(queued bool)) bool {
+	maintainConfig := config.NewAppConfig().GetSnapshot().MaintainConfig
+	m.mutex.Lock()
+	if !m.serverStop && !m.gameStop[gameID] {
+		m.addPlayer(userID, gameID)
+		m.mutex.Unlock()
+		enter(false)
+		return true
+	}
+	queued := false
+	if maintainConfig.Policy == MaintainPolicyQueue {
+		queueMax := maintainConfig.QueueMax
+		if queueMax <= 0 {
+			queueMax = defaultMaintainQueueMax
+		}
+		if len(m.waitQueue[gameID]) < queueMax {
+			m.waitQueue[gameID] = append(m.waitQueue[gameID], func() {
+				m.mutex.Lock()
+				m.addPlayer(userID, gameID)
+				m.mutex.Unlock()
+				enter(true)
+			})
+			queued = true
+		}
+	}
+	m.mutex.Unlock()
+
+	logger.Info("MaintainMgr TryEnter in maintain, userID:", userID, ",gameID:", gameID, ",queued:", queued)
+	if maintainConfig.MsgID != 0 {
+		gate.SendMsgToClient(userID, maintainConfig.MsgID, maintainConfig.Message)
+	}
+	return false
+}
+
+// Leave 玩家离开玩法，之后停该玩法时不再通知该玩家
+// 离开玩法的消息通过MsgHandlerMgr.RegisterLeaveMsgHandlerFunc注册时自动调用
+func (m *MaintainMgr) Leave(userID, gameID int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if users, ok := m.players[gameID]; ok {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(m.players, gameID)
+		}
+	}
+}
+
+// ExpirePlayers 删除超过maintainPlayerExpire没有再进入的玩家，由定时器调用
+func (m *MaintainMgr) ExpirePlayers() {
+	expireTime := time.Now().Add(-maintainPlayerExpire).Unix()
+	count := 0
+	m.mutex.Lock()
+	for gameID, users := range m.players {
+		for userID, enterTime := range users {
+			if enterTime < expireTime {
+				delete(users, userID)
+				count++
+			}
+		}
+		if len(users) == 0 {
+			delete(m.players, gameID)
+		}
+	}
+	m.mutex.Unlock()
+	if count > 0 {
+		logger.Debug("MaintainMgr expire players, count:", count)
+	}
+}
+
+// addPlayer 记录进入玩法的玩家，调用者需持有mutex
+func (m *MaintainMgr) addPlayer(userID, gameID int) {
+	users, ok := m.players[gameID]
+	if !ok {
+		users = make(map[int]int64)
+		m.players[gameID] = users
+	}
+	users[userID] = time.Now().Unix()
+}
+
+// SetMaintain 设置维护状态，gameID为0表示整个服务器
+// 整个服务器开服时同时清除所有玩法的维护状态
+func (m *MaintainMgr) SetMaintain(stop bool, gameID int) {
+	m.mutex.Lock()
+	if gameID == 0 {
+		m.serverStop = stop
+		if !stop {
+			m.gameStop = make(map[int]bool)
+		}
+	} else if stop {
+		m.gameStop[gameID] = true
+	} else {
+		delete(m.gameStop, gameID)
+	}
+	// 取出已经开服的玩法的排队请求
+	readyList := make([]func(), 0)
+	if !m.serverStop {
+		for waitGameID, queue := range m.waitQueue {
+			if !m.gameStop[waitGameID] {
+				readyList = append(readyList, queue...)
+				delete(m.waitQueue, waitGameID)
+			}
+		}
+	}
+	m.mutex.Unlock()
+
+	logger.Info("MaintainMgr SetMaintain, stop:", stop, ",gameID:", gameID, ",readyCount:", len(readyList))
+	if stop {
+		m.notifyUser(gameID)
+	}
+	if len(readyList) > 0 {
+		// 排队的请求和其他消息一样计入处理中的消息数，停服时等待处理完成
+		atomic.AddInt32(&inflightCount, int32(len(readyList)))
+		go func() {
+			for _, enter := range readyList {
+				runQueuedEnter(enter)
+			}
+		}()
+	}
+}
+
+// runQueuedEnter 执行一个排队的进入请求，panic不影响其他请求
+func runQueuedEnter(enter func()) {
+	defer atomic.AddInt32(&inflightCount, -1)
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("MaintainMgr queued enter panic, err:", err, ",stack:", string(debug.Stack()))
+		}
+	}()
+	enter()
+}
+
+// notifyUser 通知维护消息，整个服务器维护时广播给所有在线玩家，停指定玩法时只通知该玩法中的玩家
+func (m *MaintainMgr) notifyUser(gameID int) {
+	maintainConfig := config.NewAppConfig().GetSnapshot().MaintainConfig
+	if maintainConfig.MsgID == 0 {
+		return
+	}
+	if gameID == 0 {
+		gate.GetGateClientMgr().BroadcastAllGate(maintainConfig.MsgID, []byte(maintainConfig.Message))
+		logger.Info("MaintainMgr notify all user")
+		return
+	}
+	m.mutex.RLock()
+	userIDs := make([]int, 0, len(m.players[gameID]))
+	for userID := range m.players[gameID] {
+		userIDs = append(userIDs, userID)
+	}
+	m.mutex.RUnlock()
+	for _, userID := range userIDs {
+		gate.SendMsgToClient(userID, maintainConfig.MsgID, maintainConfig.Message)
+	}
+	logger.Info("MaintainMgr notify game user, gameID:", gameID, ",count:", len(userIDs))
+}
+
+// StopServerHandler 后台停服 ProtoStopServer，消息体为空时表示整个服务器停服
+func StopServerHandler(conn *gate.GateClient, userID int, msgID uint32, data []byte) {
+	msg := proto.NotifyStopTargetServer{StopFlag: 1}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &msg); err != nil {
+			logger.Error("StopServerHandler data format error,", err.Error(), ",data:", string(data))
+			return
+		}
+	}
+	GetMaintainMgr().SetMaintain(msg.StopFlag == 1, msg.GameID)
+}
+
+// StopTargetServerHandler 后台停指定服务器 ProtoStopTargetServer，ServerType和ServerID为0时不限制
+func StopTargetServerHandler(conn *gate.GateClient, userID int, msgID uint32, data []byte) {
+	var msg proto.NotifyStopTargetServer
+	if err := json.Unmarshal(data, &msg); err != nil {
+		logger.Error("StopTargetServerHandler data format error,", err.Error(), ",data:", string(data))
+		return
+	}
+	appConfig := config.NewAppConfig().GetConfig()
+	if msg.ServerType != 0 && msg.ServerType != appConfig.ServerType {
+		return
+	}
+	if msg.ServerID != 0 && msg.ServerID != appConfig.ServerID {
+		return
+	}
+	GetMaintainMgr().SetMaintain(msg.StopFlag == 1, msg.GameID)
+}
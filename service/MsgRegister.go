@@ -1,8 +1,12 @@
 package service
 
 import (
+	"context"
+	"pp/common/tracing"
 	"pp/proto"
+	"pp/service/cluster"
 	gate "pp/service/conn"
+	"strconv"
 	"sync"
 )
 
@@ -13,10 +17,13 @@ var (
 
 type HandlerMsg func(conn *gate.GateClient, userID int, msgID uint32, data []byte)
 
+// HandlerMsgCtx 带有调用跟踪的消息处理，ctx中有本次处理的span，调用其他服务器和redis、mysql时传入ctx
+type HandlerMsgCtx func(ctx context.Context, conn *gate.GateClient, userID int, msgID uint32, data []byte)
+
 func GetMsgHandlerMgr() *MsgHandlerMgr {
 	roomMsgHandlerOnce.Do(func() {
 		if mgr == nil {
-			mgr = &MsgHandlerMgr{make(map[uint32]HandlerMsg)}
+			mgr = &MsgHandlerMgr{msgHandlerFunc: make(map[uint32]HandlerMsgCtx), innerMsg: make(map[uint32]bool)}
 		}
 	})
 
@@ -24,14 +31,66 @@ func GetMsgHandlerMgr() *MsgHandlerMgr {
 }
 
 type MsgHandlerMgr struct {
-	msgHandlerFunc map[uint32]HandlerMsg
+	msgHandlerFunc map[uint32]HandlerMsgCtx
+	innerMsg       map[uint32]bool // 服务器内部消息，停服期间仍然需要处理
 }
 
 func (m *MsgHandlerMgr) RegisterMsgHandlerFunc(msgID uint32, doHandler func(conn *gate.GateClient, userID int, msgID uint32, data []byte)) {
+	m.msgHandlerFunc[msgID] = withoutCtx(doHandler)
+}
+
+// RegisterMsgHandlerFuncCtx 注册带有调用跟踪的消息处理
+func (m *MsgHandlerMgr) RegisterMsgHandlerFuncCtx(msgID uint32, doHandler HandlerMsgCtx) {
 	m.msgHandlerFunc[msgID] = doHandler
 }
 
-func (m *MsgHandlerMgr) GetMsgHandler(msgID uint32) (HandlerMsg, bool) {
+// RegisterEnterMsgHandlerFunc 注册进入玩法的消息处理，userID和gameID从消息中取出玩家ID和玩法ID
+// 玩法维护中时按maintain.policy配置拒绝或者排队，不会调用doHandler
+// 排队的请求开服后执行时原消息的处理已经结束，使用新的span，和原消息在同一个调用链中
+func (m *MsgHandlerMgr) RegisterEnterMsgHandlerFunc(msgID uint32, userID, gameID func(data []byte) int, doHandler HandlerMsgCtx) {
+	m.msgHandlerFunc[msgID] = func(ctx context.Context, conn *gate.GateClient, gateUserID int, msgID uint32, data []byte) {
+		GetMaintainMgr().TryEnter(userID(data), gameID(data), func(queued bool) {
+			if !queued {
+				doHandler(ctx, conn, gateUserID, msgID, data)
+				return
+			}
+			traceID, spanID := tracing.IDs(ctx)
+			queuedCtx, span := tracing.StartSpan(tracing.ContextWithRemote(context.Background(), traceID, spanID), "handle queued enter msg "+strconv.FormatUint(uint64(msgID), 10))
+			span.SetAttr("msgid", msgID)
+			defer span.End()
+			doHandler(queuedCtx, conn, gateUserID, msgID, data)
+		})
+	}
+}
+
+// RegisterLeaveMsgHandlerFunc 注册离开玩法的消息处理，userID和gameID从消息中取出玩家ID和玩法ID
+// 离开后停该玩法时不再通知该玩家
+func (m *MsgHandlerMgr) RegisterLeaveMsgHandlerFunc(msgID uint32, userID, gameID func(data []byte) int, doHandler HandlerMsgCtx) {
+	m.msgHandlerFunc[msgID] = func(ctx context.Context, conn *gate.GateClient, gateUserID int, msgID uint32, data []byte) {
+		GetMaintainMgr().Leave(userID(data), gameID(data))
+		doHandler(ctx, conn, gateUserID, msgID, data)
+	}
+}
+
+// registerInnerMsgHandlerFunc 注册服务器内部消息处理，停服排空期间不会被拒绝
+func (m *MsgHandlerMgr) registerInnerMsgHandlerFunc(msgID uint32, doHandler func(conn *gate.GateClient, userID int, msgID uint32, data []byte)) {
+	m.msgHandlerFunc[msgID] = withoutCtx(doHandler)
+	m.innerMsg[msgID] = true
+}
+
+// withoutCtx 不需要ctx的消息处理
+func withoutCtx(doHandler HandlerMsg) HandlerMsgCtx {
+	return func(ctx context.Context, conn *gate.GateClient, userID int, msgID uint32, data []byte) {
+		doHandler(conn, userID, msgID, data)
+	}
+}
+
+// IsInnerMsg 是否是服务器内部消息
+func (m *MsgHandlerMgr) IsInnerMsg(msgID uint32) bool {
+	return m.innerMsg[msgID]
+}
+
+func (m *MsgHandlerMgr) GetMsgHandler(msgID uint32) (HandlerMsgCtx, bool) {
 	handler, ok := m.msgHandlerFunc[msgID]
 	if !ok {
 		return nil, false
@@ -41,7 +100,12 @@ func (m *MsgHandlerMgr) GetMsgHandler(msgID uint32) (HandlerMsg, bool) {
 
 // Init 初始化网关消息处理
 func (m *MsgHandlerMgr) init() bool {
-	m.RegisterMsgHandlerFunc(proto.ClientGateBeatHeart, gate.GateClientHeartBeatHandler) // 心跳处理
+	m.registerInnerMsgHandlerFunc(proto.ClientGateBeatHeart, gate.GateClientHeartBeatHandler)          // 心跳处理
+	m.registerInnerMsgHandlerFunc(proto.ProtoNotifyInnerConnCanClose, gate.GateClientCloseRespHandler) // 网关回复可以关闭
+	m.registerInnerMsgHandlerFunc(proto.ProtoStopServer, StopServerHandler)                            // 后台停服
+	m.registerInnerMsgHandlerFunc(proto.ProtoStopTargetServer, StopTargetServerHandler)                // 后台停指定服务器
+	m.registerInnerMsgHandlerFunc(proto.ProtoNotifyServerState, cluster.NotifyServerStateHandler)      // 其他服务器状态变化
+	m.registerInnerMsgHandlerFunc(proto.ProtoServerLoadConfig, ServerLoadConfigHandler)                // 加载配置和加载结果回复
 
 	return true
 }
@@ -4,16 +4,22 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"pp/common/tracing"
 	"pp/config"
 	"pp/db/mysql"
+	"pp/service/cluster"
 	"pp/service/timer"
 
 	"pp/db/redis"
 	"pp/log"
+	"pp/service/admin"
 	serviceConfig "pp/service/config"
 	gate "pp/service/conn"
+	"pp/service/delay"
+	"pp/service/monitor"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,35 +39,60 @@ func GetSvrlibhandler() *Svrlibhandler {
 }
 
 type Svrlibhandler struct {
+	quitHooks []quitHook // 停服时需要执行的落地处理
+	hookMutex sync.Mutex
+}
+
+type quitHook struct {
+	name string
+	hook func() bool
+}
+
+// RegisterQuitHook 注册停服时的落地处理，在所有消息处理完成后、关闭网关连接前按注册顺序执行
+func (s *Svrlibhandler) RegisterQuitHook(name string, hook func() bool) {
+	s.hookMutex.Lock()
+	defer s.hookMutex.Unlock()
+	s.quitHooks = append(s.quitHooks, quitHook{name: name, hook: hook})
 }
 
 func (s *Svrlibhandler) OnInit() bool {
 	rand.Seed(time.Now().UnixNano())
+	cluster.GetClusterMgr().SetState(cluster.ServerStateStarting)
 	appConfig := config.NewAppConfig().GetConfig()
-	// 建立redis客户端链接，方便后续调用
+	// 严重错误退出前执行停服落地处理
+	logger.SetFatalHook(s.onFatal)
+	// 建立redis和mysql客户端链接，方便后续调用，所有依赖都检查完后再报告失败
+	errList := make([]error, 0)
 	redisMgr := redis.GetInstance()
 	for _, redisInfo := range appConfig.RedisConfig {
 		redisClient := &redis.RedisClient{ConnString: redisInfo.RedisAddr, Password: redisInfo.Password}
-		ok := redisClient.ConnRedis()
-		if !ok {
-			fmt.Println("get Redis client failed")
-			return false
+		if err := redisClient.ConnRedis(); err != nil {
+			errList = append(errList, fmt.Errorf("redis type %d: %w", redisInfo.RedisType, err))
+			continue
 		}
 		redisMgr.AddRedisClientByType(redisInfo.RedisType, redisClient)
 	}
-	// 建立mysql客户端链接，方便后续调用
 	mysqlMgr := mysql.NewDbMgr()
 	for _, mysqlInfo := range appConfig.MysqlConfig {
-		if mysqlInfo.DbName == "" {
-			logger.Fatal("OnInit MysqlConfig DbName is null")
-		}
-		db := mysql.NewDB(mysqlInfo.Addr, mysqlInfo.UserName, mysqlInfo.Pwd, mysqlInfo.DbName,
+		db, err := mysql.NewDB(mysqlInfo.Addr, mysqlInfo.UserName, mysqlInfo.Pwd, mysqlInfo.DbName,
 			mysql.WithDbLog(mysqlInfo.Dblog),
 			mysql.WithMaxIdleConn(25),
 			mysql.WithMaxOpenConn(126),
 			mysql.WithMaxLifetime(30*time.Minute))
+		if err != nil {
+			errList = append(errList, fmt.Errorf("mysql type %d: %w", mysqlInfo.Type, err))
+			continue
+		}
 		mysqlMgr.SetDb(mysql.DbType(mysqlInfo.Type), db)
 	}
+	if len(errList) > 0 {
+		fmt.Println("OnInit dependency failed, count:", len(errList))
+		for _, err := range errList {
+			fmt.Println("  " + err.Error())
+			logger.Error("OnInit dependency failed, ", err)
+		}
+		return false
+	}
 
 	// 注册服务器配置文件读取
 	configMgr := serviceConfig.NewAppConfigMgr()
@@ -74,10 +105,26 @@ func (s *Svrlibhandler) OnInit() bool {
 	if !logger.InitLogger() {
 		return false
 	}
+	if appConfig.AdminConfig.Enable && !admin.Start(appConfig.AdminConfig.Addr) {
+		return false
+	}
+	if appConfig.PProfConfig.Enable && !admin.StartPProf(appConfig.PProfConfig.Addr) {
+		return false
+	}
+	if appConfig.TraceConfig.Export && !startTraceExporter(&appConfig) {
+		return false
+	}
+	// 运行状态监控，超过阈值时记录日志并保存profile
+	monitor.GetMonitor().Start()
 	// 服务器启动读取所有配置
 	if !configMgr.LoadAllConfig() {
 		return false
 	}
+	config.NewAppConfig().Subscribe("diff", onAppConfigDiff)
+	if appConfig.WatchConfig.Enable && !startConfigWatcher() {
+		return false
+	}
+	config.NewAppConfig().Subscribe("watch", onWatchConfigChange)
 
 	//消息处理函数注册
 	msgHandler := GetMsgHandlerMgr()
@@ -89,10 +136,21 @@ func (s *Svrlibhandler) OnInit() bool {
 	// 读取需要连接的服务器数据
 	for _, serverInfo := range appConfig.ConnServersConfig {
 		client := &gate.GateClient{ServerID: serverInfo.ServerID, ServerType: serverInfo.ServerType, Addr: serverInfo.Addr}
-		go client.Start()
+		gate.GetGateClientMgr().StartClient(client)
 	}
+	// app.json重新加载后按变化调整网关和redis连接
+	config.NewAppConfig().Subscribe("gate", onGateConfigChange)
+	config.NewAppConfig().Subscribe("redis", onRedisConfigChange)
+	config.NewAppConfig().Subscribe("trace", onTraceConfigChange)
 	// 启动定时器
+	_ = timer.GetTickTimerMgr().Every(time.Minute, "maintain.ExpirePlayers", GetMaintainMgr().ExpirePlayers)
 	go timer.GetTickTimerMgr().Timer()
+	// 延时任务在消息处理函数注册后开始执行
+	if appConfig.DelayQueueConfig.Enable {
+		delay.GetDelayQueue().Start()
+	}
+	config.NewAppConfig().Subscribe("delayqueue", onDelayQueueConfigChange)
+	cluster.GetClusterMgr().SetState(cluster.ServerStateRunning)
 	logger.Info("OnInit success")
 	return true
 }
@@ -113,63 +171,156 @@ func (s *Svrlibhandler) InitBaseData() bool {
 }
 
 // 进程退出后需要处理相关逻辑
+// 1. 停止接收新的业务消息并通知网关停服
+// 2. 等待所有网关回复可以关闭，并且所有消息处理完成，超时时间为quittimeout
+// 3. 执行落地处理，关闭网关连接，打印未完成的内容
 func (s *Svrlibhandler) OnQuit() {
-	logger.Info("Service OnQuit Start, pid:", os.Getpid(), ", ServerName:", config.NewAppConfig().GetConfig().ServerName, "ServerID:", config.NewAppConfig().GetConfig().ServerID)
+	appConfig := config.NewAppConfig().GetConfig()
+	logger.Info("Service OnQuit Start, pid:", os.Getpid(), ", ServerName:", appConfig.ServerName, "ServerID:", appConfig.ServerID)
 
+	atomic.StoreInt32(&draining, 1)
+	stopConfigWatcher()
+	cluster.GetClusterMgr().SetState(cluster.ServerStateDraining)
 	//向网关广播服务器停服
-	gate.GetGateClientMgr().SendStopServerMsg(1)
+	gateMgr := gate.GetGateClientMgr()
+	gateMgr.SendStopServerMsg(1)
 
-	go StartMessageProcess()
-
-	//等待服务器处理完所有消息
-	count := 0
+	//等待网关回复和服务器处理完所有消息
+	quitTimeout := time.Duration(appConfig.QuitTimeout) * time.Second
+	if quitTimeout <= 0 {
+		quitTimeout = 10 * time.Second
+	}
+	deadline := time.Now().Add(quitTimeout)
+	isTimeout := false
 	for {
-		time.Sleep(time.Second)
-		if len(gate.MessageDataChan) == 0 && gate.GetGateClientMgr().Count == gate.GetGateClientMgr().StopConnCount {
+		if len(gateMgr.UnAckedClients()) == 0 && len(gate.MessageDataChan) == 0 && InflightCount() == 0 {
 			break
 		}
-		count++
-		if count > 3 {
+		if time.Now().After(deadline) {
+			isTimeout = true
 			break
 		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	unAckedGates := gateMgr.UnAckedClients()
+	ackedGates := gateMgr.StopConnCount()
+	queueCount := len(gate.MessageDataChan)
+	inflight := InflightCount()
+
+	// 停止领取延时任务，等待正在执行的任务完成
+	delay.GetDelayQueue().Stop()
+	// 释放单例定时任务的主服务器锁，其他服务器立即接替
+	timer.GetTickTimerMgr().StopLeader()
+	// 落地处理
+	failedHooks := s.runQuitHooks()
+
+	// 通知其他服务器已停止后关闭所有网关连接
+	cluster.GetClusterMgr().SetState(cluster.ServerStateStopped)
+	gateMgr.CloseAll()
+
+	if isTimeout || len(failedHooks) > 0 {
+		logger.Error("Service OnQuit drain incomplete, timeout:", isTimeout, ",unAckedGates:", unAckedGates,
+			",queueCount:", queueCount, ",inflight:", inflight, ",rejectCount:", atomic.LoadInt32(&rejectCount), ",failedHooks:", failedHooks)
+	} else {
+		logger.Info("Service OnQuit drain complete, ackedGates:", ackedGates, ",rejectCount:", atomic.LoadInt32(&rejectCount))
 	}
 	// 进程退出的时候处理
-	logger.Info("Service OnQuit End, pid:", os.Getpid(), ", ServerName:", config.NewAppConfig().GetConfig().ServerName, "ServerID:", config.NewAppConfig().GetConfig().ServerID)
+	logger.Info("Service OnQuit End, pid:", os.Getpid(), ", ServerName:", appConfig.ServerName, "ServerID:", appConfig.ServerID)
+	monitor.GetMonitor().Stop()
+	admin.Stop()
+	admin.StopPProf()
+	tracing.StopExporter()
+	// 异步日志全部写入文件后再退出
+	logger.Flush()
 }
 
-func (s *Svrlibhandler) ReloadAppConfig() {
-	appJson := config.NewAppConfig()
+// onFatal 严重错误退出前执行停服落地处理，通知其他服务器已停止并关闭网关连接
+func (s *Svrlibhandler) onFatal() {
+	atomic.StoreInt32(&draining, 1)
+	timer.GetTickTimerMgr().StopLeader()
+	failedHooks := s.runQuitHooks()
+	cluster.GetClusterMgr().SetState(cluster.ServerStateStopped)
+	gate.GetGateClientMgr().CloseAll()
+	monitor.GetMonitor().Stop()
+	admin.Stop()
+	admin.StopPProf()
+	tracing.StopExporter()
+	logger.Error("Service exit by fatal, pid:", os.Getpid(), ",failedHooks:", failedHooks)
+}
 
-	connServerList := appJson.GetConfig().ConnServersConfig[:]
-	redisList := appJson.GetConfig().RedisConfig[:]
+// runQuitHooks 执行停服落地处理，返回失败的处理名称
+func (s *Svrlibhandler) runQuitHooks() []string {
+	s.hookMutex.Lock()
+	hooks := append([]quitHook{}, s.quitHooks...)
+	s.hookMutex.Unlock()
 
-	appJson.LoadConfig()
-	//设置日志级别
-	logger.SetLevel(appJson.GetConfig().LoggerLevel)
-	logger.SetLogFileMax(appJson.GetConfig().LoggerFileMax)
+	failedHooks := make([]string, 0)
+	for _, hook := range hooks {
+		if !runQuitHook(hook) {
+			failedHooks = append(failedHooks, hook.name)
+		}
+	}
+	return failedHooks
+}
 
-	connServerListNew := appJson.GetConfig().ConnServersConfig
-	redisListNew := appJson.GetConfig().RedisConfig
+func runQuitHook(hook quitHook) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("quit hook panic, name:", hook.name, ",err:", err)
+			ok = false
+		}
+	}()
+	ok = hook.hook()
+	if !ok {
+		logger.Error("quit hook failed, name:", hook.name)
+	}
+	return ok
+}
+
+// ReloadAppConfig 重新加载app.json，各模块通过订阅配置变化处理
+func (s *Svrlibhandler) ReloadAppConfig() bool {
+	return config.NewAppConfig().LoadConfig()
+}
+
+// onGateConfigChange 新增的网关建立连接，删除的网关断开连接，地址变化的网关重新连接
+func onGateConfigChange(old, new *config.AppConfigInfo) {
+	gateMgr := gate.GetGateClientMgr()
+	for _, serverOldInfo := range old.ConnServersConfig {
+		isFind := false
+		for _, serverInfo := range new.ConnServersConfig {
+			if serverInfo.ServerID == serverOldInfo.ServerID && serverInfo.Addr == serverOldInfo.Addr {
+				isFind = true
+				break
+			}
+		}
+		if !isFind {
+			gateMgr.StopClient(serverOldInfo.ServerID)
+			logger.Info("ReloadAppConfig remove serverInfo, serverID:", serverOldInfo.ServerID, ",addr:", serverOldInfo.Addr)
+		}
+	}
 
-	for _, serverInfo := range connServerListNew {
+	for _, serverInfo := range new.ConnServersConfig {
 		isFind := false
-		for _, serverOldInfo := range connServerList {
-			if serverInfo.ServerID == serverOldInfo.ServerID {
+		for _, serverOldInfo := range old.ConnServersConfig {
+			if serverInfo.ServerID == serverOldInfo.ServerID && serverInfo.Addr == serverOldInfo.Addr {
 				isFind = true
 				break
 			}
 		}
 		if !isFind {
 			client := &gate.GateClient{ServerID: serverInfo.ServerID, ServerType: serverInfo.ServerType, Addr: serverInfo.Addr}
-			go client.Start()
+			gateMgr.StartClient(client)
 			logger.Info("ReloadAppConfig add serverInfo,", client)
 		}
 	}
+}
 
+// onRedisConfigChange 新增的redis类型建立连接
+func onRedisConfigChange(old, new *config.AppConfigInfo) {
 	redisMgr := redis.GetInstance()
-	for _, redisInfo := range redisListNew {
+	for _, redisInfo := range new.RedisConfig {
 		isFind := false
-		for _, redisOldInfo := range redisList {
+		for _, redisOldInfo := range old.RedisConfig {
 			if redisInfo.RedisType == redisOldInfo.RedisType {
 				isFind = true
 				break
@@ -177,9 +328,8 @@ func (s *Svrlibhandler) ReloadAppConfig() {
 		}
 		if !isFind {
 			redisClient := &redis.RedisClient{ConnString: redisInfo.RedisAddr, Password: redisInfo.Password}
-			ok := redisClient.ConnRedis()
-			if !ok {
-				fmt.Println("get Redis client failed")
+			if err := redisClient.ConnRedis(); err != nil {
+				logger.Error("ReloadAppConfig connect redis failed, redisType:", redisInfo.RedisType, ",err:", err)
 				continue
 			}
 			redisMgr.AddRedisClientByType(redisInfo.RedisType, redisClient)
@@ -187,3 +337,40 @@ func (s *Svrlibhandler) ReloadAppConfig() {
 		}
 	}
 }
+
+// startTraceExporter 开始导出调用链到文件
+func startTraceExporter(appConfig *config.AppConfigInfo) bool {
+	err := tracing.StartFileExporter(appConfig.TraceConfig.File, appConfig.ServerName, strconv.Itoa(appConfig.ServerID))
+	if err != nil {
+		logger.Error("start trace exporter failed, file:", appConfig.TraceConfig.File, ",err:", err)
+		return false
+	}
+	logger.Info("start trace exporter, file:", appConfig.TraceConfig.File)
+	return true
+}
+
+// onTraceConfigChange 开启、关闭调用链导出或者切换导出文件
+func onTraceConfigChange(old, new *config.AppConfigInfo) {
+	if old.TraceConfig == new.TraceConfig {
+		return
+	}
+	if !new.TraceConfig.Export {
+		tracing.StopExporter()
+		logger.Info("ReloadAppConfig stop trace exporter")
+		return
+	}
+	startTraceExporter(new)
+}
+
+// onDelayQueueConfigChange 开启或者停止执行延时任务，停止时不等待正在执行的任务完成，避免阻塞配置加载
+func onDelayQueueConfigChange(old, new *config.AppConfigInfo) {
+	if old.DelayQueueConfig.Enable == new.DelayQueueConfig.Enable {
+		return
+	}
+	if new.DelayQueueConfig.Enable {
+		delay.GetDelayQueue().Start()
+	} else {
+		delay.GetDelayQueue().StopNoWait()
+	}
+	logger.Info("ReloadAppConfig delay queue enable:", new.DelayQueueConfig.Enable)
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"pp/log"
+	"sync"
+	"time"
+)
+
+// 管理接口，默认只监听127.0.0.1:6062，用于运行时查看和调整服务状态
+// 各模块在Start之前通过HandleFunc注册自己的接口
+
+var (
+	mux         = http.NewServeMux()
+	server      *http.Server
+	serverMutex sync.Mutex
+	logger      = log.GetLogger().Module("admin")
+)
+
+// HandleFunc 注册管理接口，需要通过admin.token或者本机访问检查，publicPaths中的只读请求除外
+func HandleFunc(pattern string, handler http.HandlerFunc) {
+	mux.HandleFunc(pattern, checkAdminAccess(pattern, handler))
+}
+
+// Start 启动管理接口，已经启动时直接返回true
+func Start(addr string) bool {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	if server != nil {
+		return true
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Error("admin listen failed, addr:", addr, ",err:", err)
+		return false
+	}
+	server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func(s *http.Server) {
+		if err := s.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("admin serve failed, addr:", addr, ",err:", err)
+		}
+	}(server)
+	logger.Info("admin start, addr:", addr)
+	return true
+}
+
+// Stop 停止管理接口
+func Stop() {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = server.Shutdown(ctx)
+	server = nil
+	logger.Info("admin stop")
+}
+
+// WriteJson 返回json结果
+func WriteJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	data, err := json.Marshal(v)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+// WriteError 返回错误信息
+func WriteError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	data, _ := json.Marshal(map[string]string{"error": msg})
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"net/http"
+	"pp/log"
+	"strconv"
+	"time"
+)
+
+// 玩家调试跟踪默认和最大时长
+const (
+	defaultTraceSeconds = 600
+	maxTraceSeconds     = 86400
+)
+
+func init() {
+	HandleFunc("/log/level", logLevelHandler)
+	HandleFunc("/log/trace", logTraceHandler)
+}
+
+// logLevelHandler 日志级别
+//
+//	GET  /log/level                     查看全局和所有模块的级别
+//	POST /log/level?level=1             修改全局级别
+//	POST /log/level?module=conn&level=1 修改模块级别，0表示使用全局级别
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	appLogger := log.GetLogger()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		level, err := strconv.Atoi(r.FormValue("level"))
+		if err != nil || level < 0 || level > log.LogFatal {
+			WriteError(w, http.StatusBadRequest, "level must be 0-5")
+			return
+		}
+		module := r.FormValue("module")
+		if module == "" {
+			if level == 0 {
+				WriteError(w, http.StatusBadRequest, "global level must be 1-5")
+				return
+			}
+			appLogger.SetLevel(level)
+		} else if !appLogger.SetModuleLevel(module, level) {
+			WriteError(w, http.StatusNotFound, "module not found: "+module)
+			return
+		}
+		logger.Info("admin set log level, module:", module, ",level:", level, ",remote:", r.RemoteAddr)
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	WriteJson(w, map[string]interface{}{"level": appLogger.GetLevel(), "modules": appLogger.ModuleLevels()})
+}
+
+// logTraceHandler 玩家调试跟踪
+//
+//	GET    /log/trace                          查看正在跟踪的玩家和到期时间
+//	POST   /log/trace?userid=1001&seconds=600  开启跟踪，默认600秒
+//	DELETE /log/trace?userid=1001              关闭跟踪
+func logTraceHandler(w http.ResponseWriter, r *http.Request) {
+	appLogger := log.GetLogger()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		userID, err := strconv.Atoi(r.FormValue("userid"))
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "userid required")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			appLogger.UntraceUser(userID)
+			break
+		}
+		seconds := defaultTraceSeconds
+		if value := r.FormValue("seconds"); value != "" {
+			if seconds, err = strconv.Atoi(value); err != nil || seconds <= 0 || seconds > maxTraceSeconds {
+				WriteError(w, http.StatusBadRequest, "seconds must be 1-86400")
+				return
+			}
+		}
+		appLogger.TraceUser(userID, time.Duration(seconds)*time.Second)
+		logger.Info("admin trace user, userID:", userID, ",seconds:", seconds, ",remote:", r.RemoteAddr)
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	users := make(map[string]string)
+	for userID, expire := range appLogger.TracedUsers() {
+		users[strconv.Itoa(userID)] = expire.Format(time.DateTime)
+	}
+	WriteJson(w, users)
+}
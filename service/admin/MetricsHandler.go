@@ -0,0 +1,24 @@
+package admin
+
+import (
+	"net/http"
+	"pp/common/metrics"
+)
+
+func init() {
+	HandleFunc("/metrics", metricsHandler)
+}
+
+// metricsHandler Prometheus文本格式的指标
+//
+//	GET /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := metrics.WriteText(w); err != nil {
+		logger.Error("write metrics failed, err:", err)
+	}
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"pp/config"
+	"strings"
+	"time"
+)
+
+// pprof调试接口，和管理接口分开监听，配置了token时需要带上token访问，否则只允许本机访问
+// 管理接口使用同样的检查，token为admin.token，只有publicPaths中的只读请求不检查
+
+var pprofServer *http.Server
+
+// StartPProf 启动pprof调试接口，已经启动时直接返回true
+func StartPProf(addr string) bool {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	if pprofServer != nil {
+		return true
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Error("pprof listen failed, addr:", addr, ",err:", err)
+		return false
+	}
+	pprofMux := http.NewServeMux()
+	pprofMux.HandleFunc("/debug/pprof/", checkPProfAccess(pprof.Index))
+	pprofMux.HandleFunc("/debug/pprof/cmdline", checkPProfAccess(pprof.Cmdline))
+	pprofMux.HandleFunc("/debug/pprof/profile", checkPProfAccess(pprof.Profile))
+	pprofMux.HandleFunc("/debug/pprof/symbol", checkPProfAccess(pprof.Symbol))
+	pprofMux.HandleFunc("/debug/pprof/trace", checkPProfAccess(pprof.Trace))
+	// 采集cpu和trace时需要等待采集时长，不设置WriteTimeout
+	pprofServer = &http.Server{Handler: pprofMux, ReadHeaderTimeout: 5 * time.Second}
+	go func(s *http.Server) {
+		if err := s.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("pprof serve failed, addr:", addr, ",err:", err)
+		}
+	}(pprofServer)
+	logger.Info("pprof start, addr:", addr)
+	return true
+}
+
+// StopPProf 停止pprof调试接口
+func StopPProf() {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	if pprofServer == nil {
+		return
+	}
+	_ = pprofServer.Close()
+	pprofServer = nil
+	logger.Info("pprof stop")
+}
+
+// checkPProfAccess 检查pprof访问权限，所有请求都检查
+func checkPProfAccess(handler http.HandlerFunc) http.HandlerFunc {
+	return checkAccess("pprof", func() string { return config.NewAppConfig().GetSnapshot().PProfConfig.Token }, handler)
+}
+
+// publicPaths 不需要token也允许远程访问的管理接口，只允许GET和HEAD
+// /metrics只包含统计数据，方便prometheus从其他机器采集
+var publicPaths = map[string]bool{
+	"/metrics": true,
+}
+
+// checkAdminAccess 检查管理接口的访问权限，publicPaths中的只读请求之外都检查
+func checkAdminAccess(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	checked := checkAccess("admin", func() string { return config.NewAppConfig().GetSnapshot().AdminConfig.Token }, handler)
+	if !publicPaths[pattern] {
+		return checked
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			handler(w, r)
+			return
+		}
+		checked(w, r)
+	}
+}
+
+// checkAccess 配置了token时检查token，否则只允许本机访问，token修改后立即生效
+func checkAccess(name string, token func() string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := token(); token != "" {
+			reqToken := r.URL.Query().Get("token")
+			if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+				reqToken = strings.TrimPrefix(auth, "Bearer ")
+			}
+			if subtle.ConstantTimeCompare([]byte(reqToken), []byte(token)) != 1 {
+				logger.Warn(name, " access denied, invalid token, remote:", r.RemoteAddr, ",path:", r.URL.Path)
+				WriteError(w, http.StatusUnauthorized, "invalid token")
+				return
+			}
+		} else if !isLoopback(r.RemoteAddr) {
+			logger.Warn(name, " access denied, not local, remote:", r.RemoteAddr, ",path:", r.URL.Path)
+			WriteError(w, http.StatusForbidden, "only local access allowed without token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
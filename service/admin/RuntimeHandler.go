@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"net/http"
+	"pp/service/monitor"
+	"strconv"
+	"time"
+)
+
+func init() {
+	HandleFunc("/runtime", runtimeHandler)
+	HandleFunc("/runtime/capture", runtimeCaptureHandler)
+}
+
+// runtimeHandler 运行状态采样
+//
+//	GET /runtime  最近一次采样、当前超限的检查项和历史采样
+func runtimeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	latest, _ := monitor.GetMonitor().Latest()
+	WriteJson(w, map[string]interface{}{"latest": latest, "samples": monitor.GetMonitor().Samples()})
+}
+
+// runtimeCaptureHandler 手动采集cpu profile或者trace，结果保存在profiledir
+//
+//	GET  /runtime/capture                       查看正在进行的采集
+//	POST /runtime/capture?type=cpu&seconds=30   开始采集，type为cpu或者trace，seconds默认为captureseconds
+func runtimeCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var seconds int
+		if value := r.FormValue("seconds"); value != "" {
+			var err error
+			if seconds, err = strconv.Atoi(value); err != nil || seconds < 1 || seconds > 600 {
+				WriteError(w, http.StatusBadRequest, "seconds must be 1-600")
+				return
+			}
+		}
+		path, err := monitor.GetMonitor().Capture(r.FormValue("type"), time.Duration(seconds)*time.Second)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		logger.Info("admin capture start, type:", r.FormValue("type"), ",path:", path, ",remote:", r.RemoteAddr)
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	WriteJson(w, map[string]interface{}{"captures": monitor.GetMonitor().Captures()})
+}
@@ -0,0 +1,22 @@
+package admin
+
+import (
+	"net/http"
+	"pp/service/timer"
+)
+
+func init() {
+	HandleFunc("/timer/jobs", timerJobsHandler)
+}
+
+// timerJobsHandler 定时任务状态
+//
+//	GET /timer/jobs  是否是单例任务的主服务器，所有定时任务的下次执行时间、上次执行耗时和执行次数，按下次执行时间排序
+func timerJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	timerMgr := timer.GetTickTimerMgr()
+	WriteJson(w, map[string]interface{}{"leader": timerMgr.IsLeader(), "jobs": timerMgr.Jobs()})
+}
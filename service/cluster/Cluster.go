@@ -0,0 +1,214 @@
+package cluster
+
+import (
+	"encoding/json"
+	"math/rand"
+	"pp/config"
+	"pp/log"
+	"pp/proto"
+	gate "pp/service/conn"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 服务器生命周期状态
+const (
+	ServerStateStarting = 1 // 启动中
+	ServerStateRunning  = 2 // 运行中
+	ServerStateDraining = 3 // 停服排空中，不再接收新的业务
+	ServerStateStopped  = 4 // 已停止
+)
+
+const (
+	HeartbeatInterval    = 10 * time.Second // 定时广播本服务器状态的间隔
+	heartbeatExpireCount = 3                // 超过几个间隔没有收到状态时从状态表中删除
+)
+
+var (
+	clusterMgr     *ClusterMgr
+	clusterMgrOnce sync.Once
+	logger         = log.GetLogger().Module("cluster")
+)
+
+func GetClusterMgr() *ClusterMgr {
+	clusterMgrOnce.Do(func() {
+		if clusterMgr == nil {
+			clusterMgr = &ClusterMgr{servers: make(map[int]map[int]*ServerInfo)}
+			// 新连上的网关需要同步一次本服务器状态
+			gate.GetGateClientMgr().AddConnectListener(clusterMgr.notifyState)
+		}
+	})
+	return clusterMgr
+}
+
+// ServerInfo 集群中其他服务器的信息
+type ServerInfo struct {
+	ServerID   int       `json:"serverid"`
+	ServerType int       `json:"servertype"`
+	State      int       `json:"state"`
+	UpdateTime time.Time `json:"updatetime"` // 最后一次收到状态的时间
+}
+
+// ClusterMgr 集群视图，维护本服务器的生命周期状态和其他服务器的状态表
+type ClusterMgr struct {
+	state   int32                       // 本服务器状态
+	mutex   sync.RWMutex                // 保护servers
+	servers map[int]map[int]*ServerInfo // key为ServerType，再以ServerID为key
+}
+
+// GetState 获取本服务器状态
+func (c *ClusterMgr) GetState() int {
+	return int(atomic.LoadInt32(&c.state))
+}
+
+// SetState 设置本服务器状态，状态变化时广播给所有服务器
+func (c *ClusterMgr) SetState(state int) {
+	old := atomic.SwapInt32(&c.state, int32(state))
+	if int(old) == state {
+		return
+	}
+	logger.Info("ClusterMgr SetState, old:", old, ",new:", state)
+	gate.GetGateClientMgr().GateClientMap.Range(func(key, value interface{}) bool {
+		gateClient, ok := value.(*gate.GateClient)
+		if ok {
+			c.notifyState(gateClient)
+		}
+		return true
+	})
+}
+
+// notifyState 通过网关通知其他服务器本服务器状态
+func (c *ClusterMgr) notifyState(gateClient *gate.GateClient) {
+	state := c.GetState()
+	if state == 0 {
+		return
+	}
+	appConfig := config.NewAppConfig().GetSnapshot()
+	data, err := json.Marshal(&proto.NotifyServerState{State: state, ServerType: appConfig.ServerType, ServerID: appConfig.ServerID})
+	if err != nil {
+		logger.Error("ClusterMgr notifyState format error,", err.Error())
+		return
+	}
+	gateClient.SendMsgToGate(proto.ProtoNotifyServerState, data)
+}
+
+// Heartbeat 定时广播本服务器状态，同时删除长时间没有收到状态的服务器
+// 其他服务器异常退出时不会广播停止状态，依靠过期删除避免一直把业务路由过去
+func (c *ClusterMgr) Heartbeat() {
+	gate.GetGateClientMgr().GateClientMap.Range(func(key, value interface{}) bool {
+		gateClient, ok := value.(*gate.GateClient)
+		if ok {
+			c.notifyState(gateClient)
+		}
+		return true
+	})
+	c.expireServers(time.Now().Add(-heartbeatExpireCount * HeartbeatInterval))
+}
+
+// expireServers 删除最后一次收到状态早于deadline的服务器
+func (c *ClusterMgr) expireServers(deadline time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for serverType, servers := range c.servers {
+		for serverID, info := range servers {
+			if info.UpdateTime.Before(deadline) {
+				delete(servers, serverID)
+				logger.Warn("ClusterMgr server state expired, serverType:", serverType, ",serverID:", serverID, ",updateTime:", info.UpdateTime)
+			}
+		}
+		if len(servers) == 0 {
+			delete(c.servers, serverType)
+		}
+	}
+}
+
+// UpdateServer 更新其他服务器状态，返回是否是新的服务器或者状态有变化
+func (c *ClusterMgr) UpdateServer(msg proto.NotifyServerState) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	servers, ok := c.servers[msg.ServerType]
+	if !ok {
+		servers = make(map[int]*ServerInfo)
+		c.servers[msg.ServerType] = servers
+	}
+	old, ok := servers[msg.ServerID]
+	servers[msg.ServerID] = &ServerInfo{ServerID: msg.ServerID, ServerType: msg.ServerType, State: msg.State, UpdateTime: time.Now()}
+	return !ok || old.State != msg.State
+}
+
+// GetServer 获取指定服务器信息
+func (c *ClusterMgr) GetServer(serverType, serverID int) (ServerInfo, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	info, ok := c.servers[serverType][serverID]
+	if !ok {
+		return ServerInfo{}, false
+	}
+	return *info, true
+}
+
+// GetServersByType 获取指定类型的所有服务器信息
+func (c *ClusterMgr) GetServersByType(serverType int) []ServerInfo {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	list := make([]ServerInfo, 0, len(c.servers[serverType]))
+	for _, info := range c.servers[serverType] {
+		list = append(list, *info)
+	}
+	return list
+}
+
+// GetAllServers 获取集群中所有服务器信息
+func (c *ClusterMgr) GetAllServers() []ServerInfo {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	list := make([]ServerInfo, 0)
+	for _, servers := range c.servers {
+		for _, info := range servers {
+			list = append(list, *info)
+		}
+	}
+	return list
+}
+
+// IsAvailable 服务器是否可以接收新的业务，和RandRunningServer一致，没有收到过状态或者状态已过期的服务器视为不可用
+func (c *ClusterMgr) IsAvailable(serverType, serverID int) bool {
+	info, ok := c.GetServer(serverType, serverID)
+	return ok && info.State == ServerStateRunning
+}
+
+// RandRunningServer 随机获取一个指定类型运行中的服务器，用于路由时避开停服中的服务器
+func (c *ClusterMgr) RandRunningServer(serverType int) (ServerInfo, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	list := make([]*ServerInfo, 0)
+	for _, info := range c.servers[serverType] {
+		if info.State == ServerStateRunning {
+			list = append(list, info)
+		}
+	}
+	if len(list) == 0 {
+		return ServerInfo{}, false
+	}
+	return *list[rand.Intn(len(list))], true
+}
+
+// NotifyServerStateHandler 收到其他服务器状态变化 ProtoNotifyServerState
+func NotifyServerStateHandler(conn *gate.GateClient, userID int, msgID uint32, data []byte) {
+	var msg proto.NotifyServerState
+	if err := json.Unmarshal(data, &msg); err != nil {
+		logger.Error("NotifyServerStateHandler data format error,", err.Error(), ",data:", string(data))
+		return
+	}
+	appConfig := config.NewAppConfig().GetSnapshot()
+	if msg.ServerType == appConfig.ServerType && msg.ServerID == appConfig.ServerID {
+		return
+	}
+	// 心跳会定时重复广播状态，只在状态变化时记录Info日志
+	if GetClusterMgr().UpdateServer(msg) {
+		logger.Info("NotifyServerStateHandler state change, serverType:", msg.ServerType, ",serverID:", msg.ServerID, ",state:", msg.State)
+	} else {
+		logger.Debug("NotifyServerStateHandler, serverType:", msg.ServerType, ",serverID:", msg.ServerID, ",state:", msg.State)
+	}
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"path/filepath"
 	"pp/log"
+	"pp/proto"
+	"sync"
 )
 
 // Configer  配置接口
@@ -10,36 +13,56 @@ type Configer interface {
 	ToString() string
 }
 
+// Versioner 配置自带版本号时实现该接口，否则由配置管理按加载成功次数生成版本号
+type Versioner interface {
+	Version() int64
+}
+
+// FileConfiger 配置来自单个文件时实现该接口，文件变化时可以只重新加载对应的配置
+type FileConfiger interface {
+	Path() string
+}
+
 var (
-	appConfigMgr *appConfigManager
-	logger       = log.GetLogger()
+	appConfigMgr     *appConfigManager
+	appConfigMgrOnce sync.Once
+	logger           = log.GetLogger().Module("config")
 )
 
 type appConfigManager struct {
-	config map[string]*Configer
+	config   map[string]*Configer
+	versions map[string]int64 // 各配置加载成功的次数
+	mutex    sync.Mutex       // 保护config和versions，并保证同一时间只有一次加载
 }
 
 func NewAppConfigMgr() *appConfigManager {
-	if appConfigMgr == nil {
-		appConfigMgr = &appConfigManager{make(map[string]*Configer)}
-		logger.Info("New app config mgr")
-	}
+	appConfigMgrOnce.Do(func() {
+		if appConfigMgr == nil {
+			appConfigMgr = &appConfigManager{config: make(map[string]*Configer), versions: make(map[string]int64)}
+			logger.Info("New app config mgr")
+		}
+	})
 
 	return appConfigMgr
 }
 
 // RegisterConfig 注册配置
 func (a *appConfigManager) RegisterConfig(config *Configer) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 	a.config[(*config).ToString()] = config
 }
 
 // LoadAllConfig 加载所有配置
 func (a *appConfigManager) LoadAllConfig() bool {
-	for _, config := range a.config {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for name, config := range a.config {
 		if !(*config).LoadConfig() {
 			logger.Error("load config " + (*config).ToString() + " failed!")
 			return false
 		}
+		a.versions[name]++
 		logger.Info("load config " + (*config).ToString() + " success")
 	}
 	return true
@@ -47,18 +70,84 @@ func (a *appConfigManager) LoadAllConfig() bool {
 
 // ReloadConfig 重新加载配置
 func (a *appConfigManager) ReloadConfig(update []string) bool {
+	a.ReloadConfigWithResult(update)
+	return true
+}
+
+// ReloadConfigWithResult 重新加载配置，返回每个配置的加载结果和当前生效的版本
+func (a *appConfigManager) ReloadConfigWithResult(update []string) []proto.ConfigLoadResult {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	results := make([]proto.ConfigLoadResult, 0, len(update))
 	for _, configName := range update {
+		result := proto.ConfigLoadResult{Key: configName}
 		config, ok := a.config[configName]
-		if ok {
-			if (*config).LoadConfig() {
-				logger.Info("ReloadConfig " + configName + " success!")
-			} else {
-				logger.Error("ReloadConfig " + configName + " failed!")
-				continue
-			}
+		if !ok {
+			result.Error = "config not registered"
+			logger.Error("ReloadConfig " + configName + " not registered!")
+			results = append(results, result)
+			continue
+		}
+		if (*config).LoadConfig() {
+			a.versions[configName]++
+			result.Success = true
+			logger.Info("ReloadConfig " + configName + " success!")
+		} else {
+			result.Error = "load config failed"
+			logger.Error("ReloadConfig " + configName + " failed!")
 		}
+		result.Version = a.version(configName)
+		results = append(results, result)
 	}
-	return true
+	return results
+}
+
+// ReloadConfigByFiles 重新加载文件发生变化的配置，files为绝对路径
+func (a *appConfigManager) ReloadConfigByFiles(files []string) []proto.ConfigLoadResult {
+	changed := make(map[string]bool, len(files))
+	for _, file := range files {
+		changed[file] = true
+	}
+	// 先在锁内复制，和RegisterConfig并发时不会同时读写map
+	a.mutex.Lock()
+	configs := make(map[string]*Configer, len(a.config))
+	for name, config := range a.config {
+		configs[name] = config
+	}
+	a.mutex.Unlock()
+	update := make([]string, 0)
+	for name, config := range configs {
+		fileConfiger, ok := (*config).(FileConfiger)
+		if !ok {
+			continue
+		}
+		path, err := filepath.Abs(fileConfiger.Path())
+		if err == nil && changed[path] {
+			update = append(update, name)
+		}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	return a.ReloadConfigWithResult(update)
+}
+
+// GetVersion 获取配置当前生效的版本
+func (a *appConfigManager) GetVersion(configName string) int64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.version(configName)
+}
+
+func (a *appConfigManager) version(configName string) int64 {
+	config, ok := a.config[configName]
+	if !ok {
+		return 0
+	}
+	if versioner, ok := (*config).(Versioner); ok {
+		return versioner.Version()
+	}
+	return a.versions[configName]
 }
 
 // Init 初始化配置
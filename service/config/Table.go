@@ -0,0 +1,328 @@
+package config
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pp/config"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const defaultConfigDir = "./config"
+
+// Table 配置表，从配置目录读取json或者csv文件，生成不可变的快照
+// 重新加载时先校验，校验通过后原子替换快照，失败时保留旧快照
+// 使用方法：
+//
+//	var ItemTable = config.NewTable("item", "item.csv", func(row *Item) int { return row.ID })
+//	item, ok := ItemTable.Get(1001)
+type Table[K comparable, V any] struct {
+	name       string
+	file       string                                      // 相对配置目录的文件名，按后缀区分json和csv
+	keyFunc    func(row *V) K                              // 主键
+	indexes    map[string]func(row *V) string              // 辅助索引
+	validators []func(snapshot *TableSnapshot[K, V]) error // 校验函数
+	snapshot   atomic.Pointer[TableSnapshot[K, V]]
+}
+
+// TableSnapshot 配置表快照，生成后不再修改，返回的行数据只读
+type TableSnapshot[K comparable, V any] struct {
+	Version int64
+	rows    []*V
+	byKey   map[K]*V
+	indexes map[string]map[string][]*V
+}
+
+// NewTable 新建配置表，keyFunc返回每一行的主键，主键重复时加载失败
+func NewTable[K comparable, V any](name, file string, keyFunc func(row *V) K) *Table[K, V] {
+	return &Table[K, V]{name: name, file: file, keyFunc: keyFunc, indexes: make(map[string]func(row *V) string)}
+}
+
+// AddIndex 添加辅助索引，需要在加载前调用
+func (t *Table[K, V]) AddIndex(name string, indexFunc func(row *V) string) *Table[K, V] {
+	t.indexes[name] = indexFunc
+	return t
+}
+
+// AddValidator 添加校验函数，任意一个返回错误时本次加载失败，需要在加载前调用
+func (t *Table[K, V]) AddValidator(validator func(snapshot *TableSnapshot[K, V]) error) *Table[K, V] {
+	t.validators = append(t.validators, validator)
+	return t
+}
+
+// LoadConfig 实现Configer，加载失败时保留旧快照
+func (t *Table[K, V]) LoadConfig() bool {
+	path := t.Path()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Error("Table load file failed, name:", t.name, ",path:", path, ",err:", err)
+		return false
+	}
+	rows, err := parseTableRows[V](path, data)
+	if err != nil {
+		logger.Error("Table parse failed, name:", t.name, ",path:", path, ",err:", err)
+		return false
+	}
+
+	snapshot, err := t.buildSnapshot(rows)
+	if err != nil {
+		logger.Error("Table validate failed, keep old snapshot, name:", t.name, ",path:", path, ",err:", err)
+		return false
+	}
+	old := t.snapshot.Swap(snapshot)
+	logger.Info("Table load success, name:", t.name, ",version:", snapshot.Version, ",rows:", len(snapshot.rows))
+	if old != nil {
+		added, removed, changed := diffSnapshot(old, snapshot)
+		logger.Info("Table diff, name:", t.name, ",added:", limitKeys(added), ",removed:", limitKeys(removed), ",changed:", limitKeys(changed))
+	}
+	return true
+}
+
+// diffSnapshot 对比两个快照，返回新增、删除和内容变化的主键
+func diffSnapshot[K comparable, V any](old, new *TableSnapshot[K, V]) (added, removed, changed []K) {
+	for key, row := range new.byKey {
+		oldRow, ok := old.byKey[key]
+		if !ok {
+			added = append(added, key)
+		} else if !reflect.DeepEqual(oldRow, row) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range old.byKey {
+		if _, ok := new.byKey[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	return added, removed, changed
+}
+
+// limitKeys 日志中最多打印20个主键
+func limitKeys[K comparable](keys []K) string {
+	const maxKeys = 20
+	if len(keys) > maxKeys {
+		return fmt.Sprint(len(keys), " keys ", keys[:maxKeys], "...")
+	}
+	return fmt.Sprint(len(keys), " keys ", keys)
+}
+
+func (t *Table[K, V]) buildSnapshot(rows []*V) (*TableSnapshot[K, V], error) {
+	snapshot := &TableSnapshot[K, V]{
+		Version: t.Version() + 1,
+		rows:    make([]*V, 0, len(rows)),
+		byKey:   make(map[K]*V, len(rows)),
+		indexes: make(map[string]map[string][]*V, len(t.indexes)),
+	}
+	for name := range t.indexes {
+		snapshot.indexes[name] = make(map[string][]*V)
+	}
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+		key := t.keyFunc(row)
+		if _, ok := snapshot.byKey[key]; ok {
+			return nil, fmt.Errorf("duplicate key %v", key)
+		}
+		snapshot.byKey[key] = row
+		snapshot.rows = append(snapshot.rows, row)
+		for name, indexFunc := range t.indexes {
+			indexKey := indexFunc(row)
+			snapshot.indexes[name][indexKey] = append(snapshot.indexes[name][indexKey], row)
+		}
+	}
+	for _, validator := range t.validators {
+		if err := validator(snapshot); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// ToString 实现Configer
+func (t *Table[K, V]) ToString() string {
+	return t.name
+}
+
+// Version 实现Versioner，当前生效快照的版本，未加载时为0
+func (t *Table[K, V]) Version() int64 {
+	snapshot := t.snapshot.Load()
+	if snapshot == nil {
+		return 0
+	}
+	return snapshot.Version
+}
+
+// Path 配置文件完整路径
+func (t *Table[K, V]) Path() string {
+	configDir := config.NewAppConfig().GetSnapshot().ConfigDir
+	if configDir == "" {
+		configDir = defaultConfigDir
+	}
+	return filepath.Join(configDir, t.file)
+}
+
+// Snapshot 获取当前快照，同一次业务处理中需要多次读取时使用，保证读到的是同一个版本
+func (t *Table[K, V]) Snapshot() *TableSnapshot[K, V] {
+	snapshot := t.snapshot.Load()
+	if snapshot == nil {
+		return &TableSnapshot[K, V]{}
+	}
+	return snapshot
+}
+
+// Get 按主键查询当前快照
+func (t *Table[K, V]) Get(key K) (*V, bool) {
+	return t.Snapshot().Get(key)
+}
+
+// Lookup 按辅助索引查询当前快照
+func (t *Table[K, V]) Lookup(index, key string) []*V {
+	return t.Snapshot().Lookup(index, key)
+}
+
+// All 当前快照的所有行，顺序和文件一致
+func (t *Table[K, V]) All() []*V {
+	return t.Snapshot().All()
+}
+
+// Get 按主键查询
+func (s *TableSnapshot[K, V]) Get(key K) (*V, bool) {
+	row, ok := s.byKey[key]
+	return row, ok
+}
+
+// Lookup 按辅助索引查询
+func (s *TableSnapshot[K, V]) Lookup(index, key string) []*V {
+	return s.indexes[index][key]
+}
+
+// All 所有行，顺序和文件一致
+func (s *TableSnapshot[K, V]) All() []*V {
+	return s.rows
+}
+
+// Len 行数
+func (s *TableSnapshot[K, V]) Len() int {
+	return len(s.rows)
+}
+
+// parseTableRows 按文件后缀解析json或者csv
+func parseTableRows[V any](path string, data []byte) ([]*V, error) {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return parseCsvRows[V](data)
+	}
+	var rows []*V
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// parseCsvRows csv第一行为表头，按csv、json标签或者字段名(不区分大小写)对应到结构体字段
+// 基础类型直接转换，其他类型的单元格按json解析
+// 表头为空或者以#开头的列是备注列，不解析；其他对应不到字段的表头加载失败，避免改名后数据被静默丢弃
+func parseCsvRows[V any](data []byte) ([]*V, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("csv header not found")
+	}
+	rowType := reflect.TypeOf((*V)(nil)).Elem()
+	if rowType.Kind() != reflect.Struct {
+		return nil, errors.New("csv row must be struct")
+	}
+	fieldIndex := make([]int, len(records[0]))
+	for i, header := range records[0] {
+		header = strings.TrimSpace(strings.TrimPrefix(header, "\ufeff"))
+		if header == "" || strings.HasPrefix(header, "#") {
+			fieldIndex[i] = -1
+			continue
+		}
+		fieldIndex[i] = findCsvField(rowType, header)
+		if fieldIndex[i] < 0 {
+			return nil, fmt.Errorf("csv header %q not found in %s", header, rowType.Name())
+		}
+	}
+
+	rows := make([]*V, 0, len(records)-1)
+	for line, record := range records[1:] {
+		row := new(V)
+		rowValue := reflect.ValueOf(row).Elem()
+		for i, cell := range record {
+			if i >= len(fieldIndex) || fieldIndex[i] < 0 {
+				continue
+			}
+			if err := setCsvField(rowValue.Field(fieldIndex[i]), strings.TrimSpace(cell)); err != nil {
+				return nil, fmt.Errorf("line %d column %s: %v", line+2, records[0][i], err)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func findCsvField(rowType reflect.Type, header string) int {
+	for i := 0; i < rowType.NumField(); i++ {
+		field := rowType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Tag.Get("csv")
+		if name == "" {
+			name = strings.Split(field.Tag.Get("json"), ",")[0]
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, header) {
+			return i
+		}
+	}
+	return -1
+}
+
+func setCsvField(field reflect.Value, cell string) error {
+	if cell == "" {
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(cell)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := strconv.ParseInt(cell, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, err := strconv.ParseUint(cell, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(value)
+	case reflect.Float32, reflect.Float64:
+		value, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(value)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(cell)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	default:
+		return json.Unmarshal([]byte(cell), field.Addr().Interface())
+	}
+	return nil
+}
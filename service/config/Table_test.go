@@ -0,0 +1,126 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testReward struct {
+	ItemID int `json:"itemid"`
+	Count  int `json:"count"`
+}
+
+type testItem struct {
+	ID      int          `csv:"id" json:"id"`
+	Name    string       `json:"name"`
+	Price   float64      `json:"price"`
+	Stack   uint32       `json:"stack"`
+	Bind    bool         `json:"bind"`
+	Type    string       `json:"type"`
+	Rewards []testReward `json:"rewards"`
+	remark  string
+}
+
+func newTestItemTable() *Table[int, testItem] {
+	return NewTable("item", "item.csv", func(row *testItem) int { return row.ID }).
+		AddIndex("type", func(row *testItem) string { return row.Type })
+}
+
+func TestParseCsvRows(t *testing.T) {
+	data := "\ufeffid, Name ,PRICE,stack,bind,type,rewards,#备注\n" +
+		`1001,sword,12.5,1,true,weapon,"[{""itemid"":1,""count"":2}]",策划备注` + "\n" +
+		"1002,potion,,99,false,drug,,\n"
+	rows, err := parseTableRows[testItem]("item.csv", []byte(data))
+	if err != nil {
+		t.Fatalf("parse csv failed: %v", err)
+	}
+	want := []*testItem{
+		{ID: 1001, Name: "sword", Price: 12.5, Stack: 1, Bind: true, Type: "weapon", Rewards: []testReward{{ItemID: 1, Count: 2}}},
+		{ID: 1002, Name: "potion", Stack: 99, Type: "drug"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows = %+v, want %+v", rows, want)
+	}
+}
+
+func TestParseCsvRowsError(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", "csv header not found"},
+		{"unknown header", "id,nmae\n1,sword\n", `csv header "nmae" not found`},
+		{"unexported field", "id,remark\n1,x\n", `csv header "remark" not found`},
+		{"bad int", "id,name\nabc,sword\n", "line 2 column id"},
+		{"bad bool", "id,bind\n1,yes\n", "line 2 column bind"},
+		{"bad json cell", "id,rewards\n1,[{\n", "line 2 column rewards"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTableRows[testItem]("item.csv", []byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want contains %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJsonRows(t *testing.T) {
+	data := `[{"id":1001,"name":"sword","rewards":[{"itemid":1,"count":2}]},null,{"id":1002,"name":"potion"}]`
+	rows, err := parseTableRows[testItem]("item.JSON", []byte(data))
+	if err != nil {
+		t.Fatalf("parse json failed: %v", err)
+	}
+	if len(rows) != 3 || rows[0].Name != "sword" || rows[0].Rewards[0].Count != 2 || rows[1] != nil || rows[2].ID != 1002 {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+
+	if _, err := parseTableRows[testItem]("item.json", []byte(`{"id":1}`)); err == nil {
+		t.Fatal("json object should fail, rows must be an array")
+	}
+}
+
+func TestBuildSnapshot(t *testing.T) {
+	table := newTestItemTable()
+	rows := []*testItem{{ID: 1, Type: "weapon"}, nil, {ID: 2, Type: "drug"}, {ID: 3, Type: "weapon"}}
+	snapshot, err := table.buildSnapshot(rows)
+	if err != nil {
+		t.Fatalf("build snapshot failed: %v", err)
+	}
+	if snapshot.Version != 1 || snapshot.Len() != 3 {
+		t.Fatalf("version = %d, len = %d", snapshot.Version, snapshot.Len())
+	}
+	if row, ok := snapshot.Get(2); !ok || row.Type != "drug" {
+		t.Fatalf("Get(2) = %+v, %v", row, ok)
+	}
+	if weapons := snapshot.Lookup("type", "weapon"); len(weapons) != 2 || weapons[0].ID != 1 || weapons[1].ID != 3 {
+		t.Fatalf("Lookup weapon = %+v", weapons)
+	}
+
+	if _, err := table.buildSnapshot([]*testItem{{ID: 1}, {ID: 1}}); err == nil || !strings.Contains(err.Error(), "duplicate key 1") {
+		t.Fatalf("duplicate key err = %v", err)
+	}
+
+	table.AddValidator(func(snapshot *TableSnapshot[int, testItem]) error {
+		if _, ok := snapshot.Get(1); !ok {
+			return errors.New("item 1 required")
+		}
+		return nil
+	})
+	if _, err := table.buildSnapshot([]*testItem{{ID: 2}}); err == nil || err.Error() != "item 1 required" {
+		t.Fatalf("validator err = %v", err)
+	}
+}
+
+func TestDiffSnapshot(t *testing.T) {
+	table := newTestItemTable()
+	old, _ := table.buildSnapshot([]*testItem{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}})
+	new, _ := table.buildSnapshot([]*testItem{{ID: 2, Name: "c"}, {ID: 3, Name: "d"}})
+	added, removed, changed := diffSnapshot(old, new)
+	if !reflect.DeepEqual(added, []int{3}) || !reflect.DeepEqual(removed, []int{1}) || !reflect.DeepEqual(changed, []int{2}) {
+		t.Fatalf("added = %v, removed = %v, changed = %v", added, removed, changed)
+	}
+}
@@ -1,16 +1,19 @@
 package conn
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"pp/common"
+	"pp/common/tracing"
 	"pp/config"
 	"pp/log"
 	"pp/network"
 	"pp/network/base"
 	"pp/proto"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,7 +24,7 @@ type TcpClientMessageChan struct {
 
 var (
 	MessageDataChan chan TcpClientMessageChan = make(chan TcpClientMessageChan, 10000)
-	logger                                    = log.GetLogger()
+	logger                                    = log.GetLogger().Module("conn")
 )
 
 type GateClient struct {
@@ -31,10 +34,21 @@ type GateClient struct {
 	client       *network.NetClient // 底层socket连接
 	timeoutCount int                // 心跳超时次数
 	timestamp    int64              // 心跳开始时间
+	stopAcked    int32              // 网关是否已回复可以关闭
+	closed       int32              // 是否已主动关闭，关闭后不再重连
+	stats        network.NetStats   // 收发统计，重连后继续累计
+	connectCount uint64             // 连接建立次数，大于1时为重连
+	clientMutex  sync.Mutex         // 保护client，重连和关闭时会替换或者关闭
 }
 
+// String 只打印连接信息，避免输出内部状态
 func (g *GateClient) String() string {
-	str, err := json.Marshal(g)
+	info := struct {
+		ServerID   int
+		ServerType int
+		Addr       string
+	}{ServerID: g.ServerID, ServerType: g.ServerType, Addr: g.Addr}
+	str, err := json.Marshal(info)
 	if err != nil {
 		return ""
 	}
@@ -43,11 +57,20 @@ func (g *GateClient) String() string {
 
 // Start 启动和网关链接的客户端
 func (g *GateClient) Start() {
-	for {
+	for !g.IsClosed() {
 		// 如果链接断开，此处保持1s重连
 		client, err := network.GetConnect(g.Addr)
 		if err == nil {
+			client.Stats = &g.stats
+			g.clientMutex.Lock()
+			if g.IsClosed() {
+				g.clientMutex.Unlock()
+				client.Close()
+				return
+			}
 			g.client = client
+			g.clientMutex.Unlock()
+			atomic.AddUint64(&g.connectCount, 1)
 			// 连接建立后发送服务注册消息
 			g.RegisterServerToGate()
 			GetGateClientMgr().AddClient(g)
@@ -70,7 +93,7 @@ func (g *GateClient) Start() {
 		}
 		// 删除该链接
 		gateMgr := GetGateClientMgr()
-		gateMgr.RemoveClient(g.ServerID)
+		gateMgr.removeClient(g)
 		time.Sleep(time.Second)
 	}
 }
@@ -84,7 +107,7 @@ func (g *GateClient) RegisterServerToGate() {
 		logger.Error("register server to gate failed,", string(msg))
 		return
 	}
-	g.client.SendMsg(proto.InnerServerRegister, msg)
+	g.sendMsg(proto.InnerServerRegister, msg)
 }
 
 // SendMsgToClient 发送消息给客户端
@@ -98,7 +121,7 @@ func (g *GateClient) SendMsgToClient(userID int, msgID uint32, data string) {
 		logger.Error("SendMsgToClient,data format error,", err.Error())
 		return
 	}
-	g.client.SendMsg(proto.ServerToClient, sendData)
+	g.sendMsg(proto.ServerToClient, sendData)
 }
 
 func (g *GateClient) CheckHeartBeatTimeout() {
@@ -109,12 +132,61 @@ func (g *GateClient) CheckHeartBeatTimeout() {
 		if g.timeoutCount >= 3 {
 			g.timeoutCount = 0
 			g.timestamp = 0
-			g.client.Close()
+			if client := g.getClient(); client != nil {
+				client.Close()
+			}
 			logger.Error("CheckHeartBeatTimeout need reconnect gate")
 		}
 	}
 }
 
+// Close 主动关闭和网关的连接，关闭后不再重连
+func (g *GateClient) Close() {
+	atomic.StoreInt32(&g.closed, 1)
+	if client := g.getClient(); client != nil {
+		client.Close()
+	}
+}
+
+// getClient 当前的底层连接，还没有连接成功时为nil
+func (g *GateClient) getClient() *network.NetClient {
+	g.clientMutex.Lock()
+	defer g.clientMutex.Unlock()
+	return g.client
+}
+
+// sendMsg 通过当前的底层连接发送，还没有连接成功时丢弃
+func (g *GateClient) sendMsg(msgID uint32, data []byte) {
+	client := g.getClient()
+	if client == nil {
+		logger.Warn("GateClient not connected, drop msg, serverID:", g.ServerID, ",msgID:", msgID)
+		return
+	}
+	client.SendMsg(msgID, data)
+}
+
+// IsClosed 是否已主动关闭
+func (g *GateClient) IsClosed() bool {
+	return atomic.LoadInt32(&g.closed) == 1
+}
+
+// Stats 收发统计和重连次数
+func (g *GateClient) Stats() (stats network.NetStats, reconnects uint64) {
+	stats.BytesIn = atomic.LoadUint64(&g.stats.BytesIn)
+	stats.BytesOut = atomic.LoadUint64(&g.stats.BytesOut)
+	stats.MsgIn = atomic.LoadUint64(&g.stats.MsgIn)
+	stats.MsgOut = atomic.LoadUint64(&g.stats.MsgOut)
+	if connectCount := atomic.LoadUint64(&g.connectCount); connectCount > 1 {
+		reconnects = connectCount - 1
+	}
+	return stats, reconnects
+}
+
+// IsStopAcked 网关是否已回复可以关闭
+func (g *GateClient) IsStopAcked() bool {
+	return atomic.LoadInt32(&g.stopAcked) == 1
+}
+
 // GetHeartBeatMsg  收到心跳消息
 func (g *GateClient) GetHeartBeatMsg() {
 	g.timestamp = 0 // 设置心跳时间
@@ -122,17 +194,22 @@ func (g *GateClient) GetHeartBeatMsg() {
 }
 
 func (g *GateClient) SendHeartBeatMsg() {
-	g.client.SendMsg(proto.ClientGateBeatHeart, common.Str2bytes(`{"msgID":10000}`))
+	g.sendMsg(proto.ClientGateBeatHeart, common.Str2bytes(`{"msgID":10000}`))
 	g.timestamp = time.Now().Unix()
 }
 
 // SendStopServerMsg needRet: 是否需要返回
 func (g *GateClient) SendStopServerMsg(needRet int) {
-	g.client.SendMsg(proto.ProtoNotifyInnerConnState, common.Str2bytes(fmt.Sprintf(`{"isRet":%v}`, needRet)))
+	g.sendMsg(proto.ProtoNotifyInnerConnState, common.Str2bytes(fmt.Sprintf(`{"isRet":%v}`, needRet)))
 }
 
 // SendMsgToServer 发送消息给其他服务器
 func (g *GateClient) SendMsgToServer(serverID, serverType int, msgID uint32, data []byte) {
+	g.SendMsgToServerCtx(context.Background(), serverID, serverType, msgID, data)
+}
+
+// SendMsgToServerCtx 发送消息给其他服务器，ctx中的traceID和spanID传给对方
+func (g *GateClient) SendMsgToServerCtx(ctx context.Context, serverID, serverType int, msgID uint32, data []byte) {
 	var msg proto.ServerToServerMsg
 	msg.TargetServerID = serverID
 	msg.TargetServerType = serverType
@@ -140,17 +217,18 @@ func (g *GateClient) SendMsgToServer(serverID, serverType int, msgID uint32, dat
 	msg.ServerType = config.NewAppConfig().GetConfig().ServerType
 	msg.MsgID = msgID
 	msg.Data = string(data)
+	msg.TraceID, msg.SpanID = tracing.IDs(ctx)
 	sendData, err := json.Marshal(&msg)
 	if err != nil {
 		return
 	}
-	g.client.SendMsg(proto.ServerToServer, sendData)
-	logger.Info(fmt.Sprintf(" gateConn SendMsgToServer, %v,%v,%v,%v", g.ServerID, serverID, serverType, msgID))
+	g.sendMsg(proto.ServerToServer, sendData)
+	logger.Ctx(ctx).Info("gateConn SendMsgToServer", "gateID", g.ServerID, "serverID", serverID, "serverType", serverType, "msgID", msgID)
 }
 
 // SendMsgToGate 发消息到网关
 func (g *GateClient) SendMsgToGate(msgID uint32, data []byte) {
-	g.client.SendMsg(msgID, data)
+	g.sendMsg(msgID, data)
 }
 
 // SendMsgToGrpc 发送消息给grpc客户端
@@ -163,7 +241,7 @@ func (g *GateClient) SendMsgToGrpc(connID int, msgID uint32, data []byte) {
 	if err != nil {
 		return
 	}
-	g.client.SendMsg(proto.ServerToGrpc, sendData)
+	g.sendMsg(proto.ServerToGrpc, sendData)
 }
 
 var (
@@ -182,19 +260,114 @@ func GetGateClientMgr() *GateClientMgr {
 
 // GateClientMgr 客户端管理
 type GateClientMgr struct {
-	GateClientMap sync.Map
-	Count         int
-	timerCount    int64 // 计时器
-	StopConnCount int
+	GateClientMap sync.Map // 已连接的网关
+	startClients  sync.Map // app.json中配置的所有网关，包含重连中的
+	count         int32    // 当前连接的网关数量
+	timerCount    int64    // 计时器
+	stopConnCount int32    // 已回复可以关闭的网关数量
+	stopping      int32    // 是否已通知网关停服
+
+	listenerMutex    sync.RWMutex
+	connectListeners []func(client *GateClient) // 网关连接建立后的回调
+}
+
+// StartClient 启动一个网关客户端，断开后自动重连
+func (g *GateClientMgr) StartClient(client *GateClient) {
+	g.startClients.Store(client.ServerID, client)
+	go client.Start()
+}
+
+// StopClient 停止一个网关客户端，不再重连
+func (g *GateClientMgr) StopClient(serverID int) bool {
+	value, ok := g.startClients.LoadAndDelete(serverID)
+	if !ok {
+		return false
+	}
+	value.(*GateClient).Close()
+	return true
+}
+
+// AddConnectListener 添加网关连接建立后的回调
+func (g *GateClientMgr) AddConnectListener(listener func(client *GateClient)) {
+	g.listenerMutex.Lock()
+	defer g.listenerMutex.Unlock()
+	g.connectListeners = append(g.connectListeners, listener)
 }
 
 // AddClient 建立一个连接
 func (g *GateClientMgr) AddClient(client *GateClient) {
-	g.GateClientMap.Store(client.ServerID, client)
-	g.Count++
+	if _, loaded := g.GateClientMap.Swap(client.ServerID, client); !loaded {
+		atomic.AddInt32(&g.count, 1)
+	}
+	// 停服过程中重连上的网关需要重新通知
+	if atomic.LoadInt32(&g.stopping) == 1 {
+		client.SendStopServerMsg(1)
+	}
+	g.listenerMutex.RLock()
+	listeners := g.connectListeners
+	g.listenerMutex.RUnlock()
+	for _, listener := range listeners {
+		listener(client)
+	}
 	logger.Debug("GateClientMgr:AddClient, serverID:", client.ServerID)
 }
 
+// RangeStartClients 遍历app.json中配置的所有网关，connected为当前是否已连接
+func (g *GateClientMgr) RangeStartClients(fn func(client *GateClient, connected bool)) {
+	g.startClients.Range(func(key, value interface{}) bool {
+		client := value.(*GateClient)
+		current, ok := g.GateClientMap.Load(key)
+		fn(client, ok && current == client)
+		return true
+	})
+}
+
+// GetCount 当前连接的网关数量
+func (g *GateClientMgr) GetCount() int {
+	return int(atomic.LoadInt32(&g.count))
+}
+
+// StopConnCount 已回复可以关闭的网关数量
+func (g *GateClientMgr) StopConnCount() int {
+	return int(atomic.LoadInt32(&g.stopConnCount))
+}
+
+// AckStopConn 网关回复可以关闭，同一个网关只计一次
+func (g *GateClientMgr) AckStopConn(client *GateClient) {
+	if atomic.CompareAndSwapInt32(&client.stopAcked, 0, 1) {
+		atomic.AddInt32(&g.stopConnCount, 1)
+	}
+	logger.Info("GateClientMgr:AckStopConn, serverID:", client.ServerID)
+}
+
+// UnAckedClients 还未回复可以关闭的网关ServerID
+func (g *GateClientMgr) UnAckedClients() []int {
+	serverIDs := make([]int, 0)
+	g.GateClientMap.Range(func(key, value interface{}) bool {
+		gateClient, ok := value.(*GateClient)
+		if ok && !gateClient.IsStopAcked() {
+			serverIDs = append(serverIDs, gateClient.ServerID)
+		}
+		return true
+	})
+	return serverIDs
+}
+
+// CloseAll 关闭所有网关连接，重连中的网关也不再重连
+func (g *GateClientMgr) CloseAll() {
+	g.startClients.Range(func(key, value interface{}) bool {
+		value.(*GateClient).Close()
+		return true
+	})
+	g.GateClientMap.Range(func(key, value interface{}) bool {
+		gateClient, ok := value.(*GateClient)
+		if ok {
+			gateClient.Close()
+		}
+		return true
+	})
+}
+
 // GetClient 精确定位一个client
 func (g *GateClientMgr) GetClient(serverID int) (*GateClient, bool) {
 	client, ok := g.GateClientMap.Load(serverID)
@@ -206,27 +379,41 @@ func (g *GateClientMgr) GetClient(serverID int) (*GateClient, bool) {
 
 // RemoveClient 删除客户端
 func (g *GateClientMgr) RemoveClient(serverID int) bool {
-	_, load := g.GateClientMap.LoadAndDelete(serverID)
+	value, load := g.GateClientMap.LoadAndDelete(serverID)
 	if load {
-		if g.Count > 0 {
-			g.Count--
+		atomic.AddInt32(&g.count, -1)
+		// 断开后重连的是新连接，需要重新确认
+		if gateClient, ok := value.(*GateClient); ok && atomic.CompareAndSwapInt32(&gateClient.stopAcked, 1, 0) {
+			atomic.AddInt32(&g.stopConnCount, -1)
 		}
 	}
 	logger.Debug("GateClientMgr:RemoveClient, serverID:", serverID)
 	return true
 }
 
+// removeClient 删除客户端，同一个ServerID已经换成新的客户端时不删除
+func (g *GateClientMgr) removeClient(client *GateClient) {
+	if g.GateClientMap.CompareAndDelete(client.ServerID, client) {
+		atomic.AddInt32(&g.count, -1)
+		if atomic.CompareAndSwapInt32(&client.stopAcked, 1, 0) {
+			atomic.AddInt32(&g.stopConnCount, -1)
+		}
+	}
+	logger.Debug("GateClientMgr:removeClient, serverID:", client.ServerID)
+}
+
 // RandOneClient 随机找一个网关发送消息
 func (g *GateClientMgr) RandOneClient() (*GateClient, bool) {
-	if g.Count == 0 {
+	count := g.GetCount()
+	if count <= 0 {
 		return nil, false
 	}
-	randCount := rand.Intn(g.Count)
-	count := 0
+	randCount := rand.Intn(count)
+	index := 0
 	var client *GateClient
 	g.GateClientMap.Range(func(key, value interface{}) bool {
-		count++
-		if count >= randCount {
+		index++
+		if index >= randCount {
 			gateClient, ok := value.(*GateClient)
 			if ok {
 				client = gateClient
@@ -280,6 +467,7 @@ func (g *GateClientMgr) Time1min() {
 }
 
 func (g *GateClientMgr) SendStopServerMsg(needRet int) {
+	atomic.StoreInt32(&g.stopping, 1)
 	g.GateClientMap.Range(func(key, value interface{}) bool {
 		gateClient, ok := value.(*GateClient)
 		if ok {
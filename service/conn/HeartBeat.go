@@ -5,6 +5,7 @@ func GateClientHeartBeatHandler(conn *GateClient, serverID int, msgID uint32, da
 	conn.GetHeartBeatMsg()
 }
 
+// GateClientCloseRespHandler 网关回复可以关闭
 func GateClientCloseRespHandler(conn *GateClient, serverID int, msgID uint32, data []byte) {
-	GetGateClientMgr().StopConnCount++
+	GetGateClientMgr().AckStopConn(conn)
 }
@@ -0,0 +1,55 @@
+package conn
+
+import (
+	"pp/common/metrics"
+	"strconv"
+)
+
+func init() {
+	metrics.NewGaugeFunc("pp_message_chan_depth", "MessageDataChan中等待处理的消息数", func() float64 {
+		return float64(len(MessageDataChan))
+	})
+	metrics.NewGaugeFunc("pp_message_chan_capacity", "MessageDataChan容量", func() float64 {
+		return float64(cap(MessageDataChan))
+	})
+	gateLabels := []string{"serverid", "addr"}
+	metrics.NewCollector("pp_gate_connected", "网关连接状态 1：已连接 0：未连接", metrics.TypeGauge, gateLabels, func(emit func(float64, ...string)) {
+		GetGateClientMgr().RangeStartClients(func(client *GateClient, connected bool) {
+			value := 0.0
+			if connected {
+				value = 1
+			}
+			emit(value, strconv.Itoa(client.ServerID), client.Addr)
+		})
+	})
+	metrics.NewCollector("pp_gate_reconnects_total", "网关重连次数", metrics.TypeCounter, gateLabels, func(emit func(float64, ...string)) {
+		GetGateClientMgr().RangeStartClients(func(client *GateClient, connected bool) {
+			_, reconnects := client.Stats()
+			emit(float64(reconnects), strconv.Itoa(client.ServerID), client.Addr)
+		})
+	})
+	metrics.NewCollector("pp_gate_bytes_in_total", "从网关收到的字节数", metrics.TypeCounter, gateLabels, func(emit func(float64, ...string)) {
+		GetGateClientMgr().RangeStartClients(func(client *GateClient, connected bool) {
+			stats, _ := client.Stats()
+			emit(float64(stats.BytesIn), strconv.Itoa(client.ServerID), client.Addr)
+		})
+	})
+	metrics.NewCollector("pp_gate_bytes_out_total", "发送到网关的字节数", metrics.TypeCounter, gateLabels, func(emit func(float64, ...string)) {
+		GetGateClientMgr().RangeStartClients(func(client *GateClient, connected bool) {
+			stats, _ := client.Stats()
+			emit(float64(stats.BytesOut), strconv.Itoa(client.ServerID), client.Addr)
+		})
+	})
+	metrics.NewCollector("pp_gate_messages_in_total", "从网关收到的消息数", metrics.TypeCounter, gateLabels, func(emit func(float64, ...string)) {
+		GetGateClientMgr().RangeStartClients(func(client *GateClient, connected bool) {
+			stats, _ := client.Stats()
+			emit(float64(stats.MsgIn), strconv.Itoa(client.ServerID), client.Addr)
+		})
+	})
+	metrics.NewCollector("pp_gate_messages_out_total", "发送到网关的消息数", metrics.TypeCounter, gateLabels, func(emit func(float64, ...string)) {
+		GetGateClientMgr().RangeStartClients(func(client *GateClient, connected bool) {
+			stats, _ := client.Stats()
+			emit(float64(stats.MsgOut), strconv.Itoa(client.ServerID), client.Addr)
+		})
+	})
+}
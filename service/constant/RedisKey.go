@@ -5,6 +5,20 @@ import "fmt"
 const (
 	// PlayerStatusRedisKey 玩家所在的网关数据，玩家是否离线数
 	PlayerStatusRedisKey = "player:roomProfile:hash:%d"
+	// TimerLeaderRedisKey 单例定时任务的主服务器锁，按服务器类型
+	TimerLeaderRedisKey = "timer:leader:string:%d"
+	// TimerLastRunRedisKey 单例定时任务上次成功执行的计划时间(毫秒)，field为任务名，按服务器类型
+	TimerLastRunRedisKey = "timer:lastrun:hash:%d"
+	// DelayQueueRedisKey 等待执行的延时任务，member为任务ID，score为到期时间(毫秒)，按服务器类型
+	DelayQueueRedisKey = "delay:queue:zset:%d"
+	// DelayProcessingRedisKey 已经领取正在执行的延时任务，score为执行超时时间(毫秒)，按服务器类型
+	DelayProcessingRedisKey = "delay:processing:zset:%d"
+	// DelayTaskRedisKey 延时任务内容，field为任务ID，按服务器类型
+	DelayTaskRedisKey = "delay:task:hash:%d"
+	// DelayDeadRedisKey 超过最多执行次数的延时任务，按服务器类型
+	DelayDeadRedisKey = "delay:dead:list:%d"
+	// DelayAttemptsRedisKey 延时任务已经领取的次数，field为任务ID，按服务器类型
+	DelayAttemptsRedisKey = "delay:attempts:hash:%d"
 )
 
 // 相关redis key
@@ -12,3 +26,24 @@ const (
 func GetPlayerStatusKey(userId int) string {
 	return fmt.Sprintf(PlayerStatusRedisKey, userId)
 }
+
+// GetTimerLeaderKey 获取单例定时任务主服务器锁 redis key
+func GetTimerLeaderKey(serverType int) string {
+	return fmt.Sprintf(TimerLeaderRedisKey, serverType)
+}
+
+// GetTimerLastRunKey 获取单例定时任务上次执行时间 redis key
+func GetTimerLastRunKey(serverType int) string {
+	return fmt.Sprintf(TimerLastRunRedisKey, serverType)
+}
+
+// GetDelayQueueKeys 获取延时任务队列 redis key：等待、执行中、任务内容、死信
+func GetDelayQueueKeys(serverType int) (queue, processing, task, dead string) {
+	return fmt.Sprintf(DelayQueueRedisKey, serverType), fmt.Sprintf(DelayProcessingRedisKey, serverType),
+		fmt.Sprintf(DelayTaskRedisKey, serverType), fmt.Sprintf(DelayDeadRedisKey, serverType)
+}
+
+// GetDelayAttemptsKey 获取延时任务领取次数 redis key
+func GetDelayAttemptsKey(serverType int) string {
+	return fmt.Sprintf(DelayAttemptsRedisKey, serverType)
+}
@@ -0,0 +1,496 @@
+package delay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"pp/common/metrics"
+	"pp/config"
+	"pp/db/redis"
+	"pp/log"
+	"pp/service/constant"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// redis延时任务队列，用于几分钟到几天后执行、需要在重启后保留的任务，例如赛季结束、邮件过期、退款检查
+// 任务保存在按服务器类型区分的redis key中，同类型的服务器共同领取执行，领取通过lua脚本保证同一时间只有一个服务器执行
+// 执行失败按重试间隔重新投递，超过最多执行次数后放入死信列表；领取后超时没有确认的任务会重新投递，所以任务至少执行一次，处理需要可以重复执行
+
+var (
+	queue     *DelayQueue
+	queueOnce sync.Once
+	logger    = log.GetLogger().Module("delay")
+
+	taskRuns     = metrics.NewCounterVec("pp_delay_task_runs_total", "延时任务执行次数 result：ok retry dead", "type", "result")
+	taskDuration = metrics.NewHistogramVec("pp_delay_task_duration_seconds", "延时任务执行耗时(秒)", nil, "type")
+)
+
+// 执行超时后多等待的时间再重新投递，处理函数在ctx取消后有时间结束
+const visibilityMargin = 10 * time.Second
+
+// 保存任务内容并加入等待队列，一次执行保证领取时一定有内容
+const pushScript = `
+redis.call("hset", KEYS[2], ARGV[1], ARGV[2])
+redis.call("zadd", KEYS[1], ARGV[3], ARGV[1])
+return 1`
+
+// 领取到期任务：从等待队列移到执行中队列，score为执行超时时间，同时作为本次领取的凭证
+// 领取次数在领取时记录，执行中进程退出或者卡住也会计数，返回任务ID和领取次数
+const claimScript = `
+local ids = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+local ret = {}
+for _, id in ipairs(ids) do
+	redis.call("zrem", KEYS[1], id)
+	redis.call("zadd", KEYS[2], ARGV[2], id)
+	ret[#ret + 1] = id
+	ret[#ret + 1] = redis.call("hincrby", KEYS[3], id, 1)
+end
+return ret`
+
+// 执行超时的任务重新放回等待队列，领取次数达到ARGV[3]的放入死信列表，返回重新投递和放入死信的数量
+// 放入死信时解析内容后更新领取次数和失败原因，内容无法解析时保留原内容
+const requeueScript = `
+local ids = redis.call("zrangebyscore", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+local dead = 0
+for _, id in ipairs(ids) do
+	redis.call("zrem", KEYS[2], id)
+	local attempts = tonumber(redis.call("hget", KEYS[4], id)) or 0
+	if attempts >= tonumber(ARGV[3]) then
+		local payload = redis.call("hget", KEYS[3], id)
+		redis.call("hdel", KEYS[3], id)
+		redis.call("hdel", KEYS[4], id)
+		if payload then
+			local ok, task = pcall(cjson.decode, payload)
+			if ok and type(task) == "table" then
+				task["attempts"] = attempts
+				task["lasterror"] = "timeout"
+				payload = cjson.encode(task)
+			end
+			redis.call("lpush", KEYS[5], payload)
+			redis.call("ltrim", KEYS[5], 0, tonumber(ARGV[4]) - 1)
+		end
+		dead = dead + 1
+	else
+		redis.call("zadd", KEYS[1], ARGV[1], id)
+	end
+end
+return {#ids - dead, dead}`
+
+// 结束一次领取，执行中队列的score和领取时的凭证相同时才处理，超时后被重新领取的任务不受影响
+// ack：删除任务 retry：更新内容后在ARGV[5]时间重新执行 dead：放入死信列表，死信列表只保留最近的ARGV[6]个
+const finishScript = `
+local score = redis.call("zscore", KEYS[1], ARGV[1])
+if not score or tonumber(score) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("zrem", KEYS[1], ARGV[1])
+if ARGV[3] == "retry" then
+	redis.call("hset", KEYS[3], ARGV[1], ARGV[4])
+	redis.call("zadd", KEYS[2], ARGV[5], ARGV[1])
+	return 1
+end
+redis.call("hdel", KEYS[3], ARGV[1])
+redis.call("hdel", KEYS[4], ARGV[1])
+if ARGV[3] == "dead" then
+	redis.call("lpush", KEYS[5], ARGV[4])
+	redis.call("ltrim", KEYS[5], 0, tonumber(ARGV[6]) - 1)
+end
+return 1`
+
+func GetDelayQueue() *DelayQueue {
+	queueOnce.Do(func() {
+		if queue == nil {
+			queue = &DelayQueue{handlers: make(map[string]Handler)}
+		}
+	})
+	return queue
+}
+
+// Task 延时任务
+type Task struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Data       json.RawMessage `json:"data"`
+	Attempts   int             `json:"attempts"`            // 已经领取的次数，包括本次
+	CreateTime int64           `json:"createtime"`          // 添加时间(毫秒)
+	DueTime    int64           `json:"duetime"`             // 计划执行时间(毫秒)
+	LastError  string          `json:"lasterror,omitempty"` // 上次执行失败的原因
+}
+
+// Decode 解析任务数据
+func (t *Task) Decode(v interface{}) error {
+	return json.Unmarshal(t.Data, v)
+}
+
+// Handler 延时任务处理，返回错误时按重试间隔重新执行，ctx在执行超时后取消
+type Handler func(ctx context.Context, task *Task) error
+
+// Stats 延时任务队列状态
+type Stats struct {
+	Waiting    int64 `json:"waiting"`
+	Processing int64 `json:"processing"`
+	Dead       int64 `json:"dead"`
+}
+
+// DelayQueue 延时任务队列
+type DelayQueue struct {
+	mutex    sync.Mutex
+	handlers map[string]Handler
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// RegisterHandler 注册任务类型的处理，同类型的所有服务器都需要注册
+func (q *DelayQueue) RegisterHandler(taskType string, handler Handler) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.handlers[taskType] = handler
+}
+
+func (q *DelayQueue) getHandler(taskType string) (Handler, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	handler, ok := q.handlers[taskType]
+	return handler, ok
+}
+
+// Push delay之后执行任务，data会转换为json，返回任务ID
+func (q *DelayQueue) Push(taskType string, data interface{}, delay time.Duration) (string, error) {
+	return q.PushAt(taskType, data, time.Now().Add(delay))
+}
+
+// PushAt 在at时间执行任务，返回任务ID
+func (q *DelayQueue) PushAt(taskType string, data interface{}, at time.Time) (string, error) {
+	client, conf, err := q.client()
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	id, err := newTaskID()
+	if err != nil {
+		return "", err
+	}
+	task := &Task{ID: id, Type: taskType, Data: raw, CreateTime: time.Now().UnixMilli(), DueTime: at.UnixMilli()}
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return "", err
+	}
+	queueKey, _, taskKey, _ := constant.GetDelayQueueKeys(conf.ServerType)
+	if _, err = client.EvalLua(pushScript, []string{queueKey, taskKey}, id, payload, task.DueTime); err != nil {
+		return "", fmt.Errorf("delay task add failed, id:%s, err:%w", id, err)
+	}
+	logger.Debug("delay task push, id:", id, ",type:", taskType, ",at:", at.Format(time.DateTime))
+	return id, nil
+}
+
+// Cancel 取消还没有领取的任务，已经领取或者不存在时返回false
+func (q *DelayQueue) Cancel(id string) bool {
+	client, conf, err := q.client()
+	if err != nil {
+		return false
+	}
+	queueKey, _, taskKey, _ := constant.GetDelayQueueKeys(conf.ServerType)
+	if client.ZRem(queueKey, id) == 0 {
+		return false
+	}
+	client.HDel(taskKey, id)
+	client.HDel(constant.GetDelayAttemptsKey(conf.ServerType), id)
+	return true
+}
+
+// Pending 最早到期的count个等待中的任务ID
+func (q *DelayQueue) Pending(count int64) []string {
+	client, conf, err := q.client()
+	if err != nil {
+		return nil
+	}
+	queueKey, _, _, _ := constant.GetDelayQueueKeys(conf.ServerType)
+	return client.ZRangeByScore(queueKey, 0, 1<<62, 0, count)
+}
+
+// Stats 等待、执行中和死信任务数
+func (q *DelayQueue) Stats() Stats {
+	client, conf, err := q.client()
+	if err != nil {
+		return Stats{}
+	}
+	queueKey, processingKey, _, deadKey := constant.GetDelayQueueKeys(conf.ServerType)
+	return Stats{Waiting: client.ZCard(queueKey), Processing: client.ZCard(processingKey), Dead: client.LLen(deadKey)}
+}
+
+// DeadTasks 死信列表中最近的count个任务
+func (q *DelayQueue) DeadTasks(count int64) []*Task {
+	client, conf, err := q.client()
+	if err != nil {
+		return nil
+	}
+	_, _, _, deadKey := constant.GetDelayQueueKeys(conf.ServerType)
+	tasks := make([]*Task, 0)
+	for _, payload := range client.LRange(deadKey, 0, count-1) {
+		task := &Task{}
+		if json.Unmarshal([]byte(payload), task) == nil {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// Start 开始领取执行到期的任务，已经开始时不处理
+func (q *DelayQueue) Start() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.stopChan != nil {
+		return
+	}
+	q.stopChan = make(chan struct{})
+	q.done = make(chan struct{})
+	go q.run(q.stopChan, q.done)
+	logger.Info("delay queue start")
+}
+
+// Stop 停止领取任务，等待正在执行的任务完成
+func (q *DelayQueue) Stop() {
+	if done := q.stop(); done != nil {
+		<-done
+		logger.Info("delay queue stop")
+	}
+}
+
+// StopNoWait 停止领取任务，不等待正在执行的任务完成，配置重新加载时使用，之后可以立即重新Start
+func (q *DelayQueue) StopNoWait() {
+	if q.stop() != nil {
+		logger.Info("delay queue stopping")
+	}
+}
+
+// stop 通知执行协程退出，返回执行协程退出的通知，没有开始时返回nil
+func (q *DelayQueue) stop() chan struct{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.stopChan == nil {
+		return nil
+	}
+	close(q.stopChan)
+	q.stopChan = nil
+	return q.done
+}
+
+// run 按配置的间隔领取任务，同时执行的任务最多BatchSize个，有任务执行完后继续领取
+// 停止后等待正在执行的任务完成再退出
+func (q *DelayQueue) run(stopChan, done chan struct{}) {
+	var wg sync.WaitGroup
+	var active int32
+	finished := make(chan struct{}, 1)
+	defer func() {
+		wg.Wait()
+		close(done)
+	}()
+	start := func(process func()) {
+		atomic.AddInt32(&active, 1)
+		wg.Add(1)
+		go func() {
+			defer func() {
+				atomic.AddInt32(&active, -1)
+				wg.Done()
+				select {
+				case finished <- struct{}{}:
+				default:
+				}
+			}()
+			process()
+		}()
+	}
+	for {
+		conf := config.NewAppConfig().GetSnapshot().DelayQueueConfig
+		// 执行中的任务已满时等待有任务执行完，按间隔检查时也会重新投递其他服务器超时的任务
+		var wake chan struct{}
+		if free := conf.BatchSize - int(atomic.LoadInt32(&active)); free > 0 {
+			// 领取满时可能还有到期任务，立即继续领取
+			if q.poll(&conf, free, start) >= free {
+				select {
+				case <-stopChan:
+					return
+				default:
+					continue
+				}
+			}
+		} else {
+			wake = finished
+		}
+		timer := time.NewTimer(time.Duration(conf.Interval) * time.Millisecond)
+		select {
+		case <-stopChan:
+			timer.Stop()
+			return
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// poll 重新投递执行超时的任务，最多领取limit个到期任务交给start执行，返回领取的任务数
+func (q *DelayQueue) poll(conf *config.DelayQueueConfig, limit int, start func(process func())) int {
+	client, appConf, err := q.client()
+	if err != nil {
+		return 0
+	}
+	queueKey, processingKey, taskKey, deadKey := constant.GetDelayQueueKeys(appConf.ServerType)
+	attemptsKey := constant.GetDelayAttemptsKey(appConf.ServerType)
+	now := time.Now().UnixMilli()
+	ret, err := client.EvalLua(requeueScript, []string{queueKey, processingKey, taskKey, attemptsKey, deadKey}, now, conf.BatchSize, conf.MaxAttempts, conf.DeadMax)
+	if err != nil {
+		logger.Error("delay task requeue failed, err:", err)
+	} else if counts, _ := ret.([]interface{}); len(counts) == 2 {
+		if counts[0].(int64) > 0 {
+			logger.Warn("delay task timeout requeue, count:", counts[0])
+		}
+		if counts[1].(int64) > 0 {
+			logger.Error("delay task timeout dead, count:", counts[1])
+		}
+	}
+
+	// 超时时间比处理的ctx多visibilityMargin，同时作为本次领取的凭证
+	deadline := now + int64(conf.Visibility)*1000 + visibilityMargin.Milliseconds()
+	ret, err = client.EvalLua(claimScript, []string{queueKey, processingKey, attemptsKey}, now, deadline, limit)
+	if err != nil {
+		logger.Error("delay task claim failed, err:", err)
+		return 0
+	}
+	claimed, _ := ret.([]interface{})
+	for i := 0; i+1 < len(claimed); i += 2 {
+		id, _ := claimed[i].(string)
+		attempts, _ := claimed[i+1].(int64)
+		start(func() {
+			q.process(client, appConf.ServerType, conf, id, int(attempts), deadline)
+		})
+	}
+	return len(claimed) / 2
+}
+
+// process 执行一个已经领取的任务，成功后删除，失败后重新投递或者放入死信列表
+// token为领取凭证，执行超时后任务已经被其他服务器重新领取时不再修改
+func (q *DelayQueue) process(client *redis.RedisClient, serverType int, conf *config.DelayQueueConfig, id string, attempts int, token int64) {
+	queueKey, processingKey, taskKey, deadKey := constant.GetDelayQueueKeys(serverType)
+	keys := []string{processingKey, queueKey, taskKey, constant.GetDelayAttemptsKey(serverType), deadKey}
+	finish := func(action string, payload []byte, retryAt int64) bool {
+		ret, err := client.EvalLua(finishScript, keys, id, token, action, payload, retryAt, conf.DeadMax)
+		if err != nil {
+			logger.Error("delay task finish failed, id:", id, ",action:", action, ",err:", err)
+			return false
+		}
+		if ret != int64(1) {
+			logger.Warn("delay task claim lost, id:", id, ",action:", action, ",attempts:", attempts)
+			return false
+		}
+		return true
+	}
+
+	payload, err := client.HGet(taskKey, id)
+	if errors.Is(err, redis.Nil) {
+		// 没有内容的任务无法执行，直接删除
+		logger.Error("delay task payload missing, id:", id)
+		finish("ack", nil, 0)
+		return
+	}
+	if err != nil {
+		// redis出错时不结束领取，执行超时后重新投递
+		logger.Error("delay task load failed, id:", id, ",err:", err)
+		return
+	}
+	task := &Task{}
+	if err = json.Unmarshal([]byte(payload), task); err != nil {
+		// 内容格式错误无法执行，保留原内容放入死信列表
+		if finish("dead", []byte(payload), 0) {
+			logger.Error("delay task payload invalid, id:", id, ",err:", err)
+		}
+		return
+	}
+
+	task.Attempts = attempts
+	start := time.Now()
+	err = q.execute(task, time.Duration(conf.Visibility)*time.Second)
+	taskDuration.With(task.Type).Observe(time.Since(start).Seconds())
+	if err == nil {
+		finish("ack", nil, 0)
+		taskRuns.With(task.Type, "ok").Inc()
+		logger.Debug("delay task done, id:", id, ",type:", task.Type, ",attempts:", task.Attempts)
+		return
+	}
+
+	task.LastError = err.Error()
+	payloadBytes, _ := json.Marshal(task)
+	if task.Attempts >= conf.MaxAttempts {
+		if finish("dead", payloadBytes, 0) {
+			taskRuns.With(task.Type, "dead").Inc()
+			logger.Error("delay task dead, id:", id, ",type:", task.Type, ",attempts:", task.Attempts, ",err:", err)
+		}
+		return
+	}
+	retryAt := time.Now().Add(retryDelay(task.Attempts)).UnixMilli()
+	if finish("retry", payloadBytes, retryAt) {
+		taskRuns.With(task.Type, "retry").Inc()
+		logger.Warn("delay task retry, id:", id, ",type:", task.Type, ",attempts:", task.Attempts, ",retryAt:", time.UnixMilli(retryAt).Format(time.DateTime), ",err:", err)
+	}
+}
+
+// execute 调用任务类型的处理，panic作为执行失败
+func (q *DelayQueue) execute(task *Task, timeout time.Duration) (err error) {
+	handler, ok := q.getHandler(task.Type)
+	if !ok {
+		return errors.New("no handler for type " + task.Type)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			logger.Error("delay task panic, id:", task.ID, ",type:", task.Type, ",err:", r, ",stack:", string(debug.Stack()))
+		}
+	}()
+	return handler(ctx, task)
+}
+
+// client 延时任务使用的redis连接和当前配置
+func (q *DelayQueue) client() (*redis.RedisClient, *config.AppConfigInfo, error) {
+	appConf := config.NewAppConfig().GetSnapshot()
+	if appConf == nil {
+		return nil, nil, errors.New("app config not loaded")
+	}
+	client, index := redis.GetInstance().GetRedisClientByType(appConf.DelayQueueConfig.RedisType)
+	if index == 0 {
+		return nil, nil, errors.New("redis type " + strconv.Itoa(appConf.DelayQueueConfig.RedisType) + " not connected")
+	}
+	return client, appConf, nil
+}
+
+// retryDelay 第attempts次失败后的重试间隔，从10秒开始翻倍，最长1小时
+func retryDelay(attempts int) time.Duration {
+	delay := 10 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// newTaskID 随机任务ID
+func newTaskID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
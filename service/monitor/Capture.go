@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"pp/config"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+// 手动采集，用于线上问题排查，通过管理接口或者信号触发，结果保存在ProfileDir
+const (
+	CaptureCPU   = "cpu"
+	CaptureTrace = "trace"
+)
+
+// capture 正在进行的采集
+type capture struct {
+	path  string
+	end   time.Time
+	file  *os.File
+	timer *time.Timer
+}
+
+// CaptureInfo 正在进行的采集信息
+type CaptureInfo struct {
+	Type string    `json:"type"`
+	Path string    `json:"path"`
+	End  time.Time `json:"end"`
+}
+
+// Capture 开始采集cpu profile或者执行trace，duration为0时使用配置的默认时长
+// 同一类型同时只能有一个采集，返回保存的文件路径，采集结束后文件才完整
+func (m *Monitor) Capture(kind string, duration time.Duration) (string, error) {
+	conf := config.NewAppConfig().GetSnapshot().MonitorConfig
+	if duration <= 0 {
+		duration = time.Duration(conf.CaptureSeconds) * time.Second
+	}
+	var ext string
+	switch kind {
+	case CaptureCPU:
+		ext = ".pb.gz"
+	case CaptureTrace:
+		ext = ".out"
+	default:
+		return "", fmt.Errorf("unknown capture type: %s", kind)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if c, ok := m.captures[kind]; ok {
+		return "", fmt.Errorf("%s capture already running, path:%s", kind, c.path)
+	}
+	if err := os.MkdirAll(conf.ProfileDir, 0755); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	path := filepath.Join(conf.ProfileDir, kind+"-"+now.Format("20060102150405")+ext)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if kind == CaptureCPU {
+		err = pprof.StartCPUProfile(file)
+	} else {
+		err = trace.Start(file)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(path)
+		return "", err
+	}
+	// 新的采集文件也计入保留数量，进行中的文件是最新的不会被删除
+	pruneFiles(conf.ProfileDir, kind+"-", ext, conf.ProfileKeep)
+	c := &capture{path: path, end: now.Add(duration), file: file}
+	c.timer = time.AfterFunc(duration, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if m.captures[kind] == c {
+			m.stopCapture(kind, c)
+		}
+	})
+	if m.captures == nil {
+		m.captures = make(map[string]*capture)
+	}
+	m.captures[kind] = c
+	logger.Warn("capture start, type:", kind, ",duration:", duration, ",path:", path)
+	return path, nil
+}
+
+// Captures 正在进行的采集
+func (m *Monitor) Captures() []CaptureInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	infoList := make([]CaptureInfo, 0, len(m.captures))
+	for kind, c := range m.captures {
+		infoList = append(infoList, CaptureInfo{Type: kind, Path: c.path, End: c.end})
+	}
+	return infoList
+}
+
+// stopCaptures 提前结束所有采集，保证退出前文件完整
+func (m *Monitor) stopCaptures() {
+	for kind, c := range m.captures {
+		c.timer.Stop()
+		m.stopCapture(kind, c)
+	}
+}
+
+func (m *Monitor) stopCapture(kind string, c *capture) {
+	if kind == CaptureCPU {
+		pprof.StopCPUProfile()
+	} else {
+		trace.Stop()
+	}
+	if err := c.file.Close(); err != nil {
+		logger.Error("capture close failed, type:", kind, ",path:", c.path, ",err:", err)
+	}
+	delete(m.captures, kind)
+	logger.Warn("capture end, type:", kind, ",path:", c.path)
+}
@@ -0,0 +1,285 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"pp/config"
+	"pp/log"
+	gate "pp/service/conn"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 运行状态监控，定时采样协程数、堆内存、GC暂停和消息队列长度
+// 只在超过阈值和恢复时记录日志，超过阈值时自动保存profile，采样结果通过管理接口查看
+
+// 阈值检查项
+const (
+	CheckGoroutines = "goroutines"
+	CheckHeap       = "heap"
+	CheckGCPause    = "gcpause"
+	CheckQueue      = "queue"
+)
+
+var (
+	monitor     *Monitor
+	monitorOnce sync.Once
+	logger      = log.GetLogger().Module("monitor")
+)
+
+func GetMonitor() *Monitor {
+	monitorOnce.Do(func() {
+		if monitor == nil {
+			monitor = &Monitor{breached: make(map[string]bool), lastProfile: make(map[string]time.Time)}
+		}
+	})
+	return monitor
+}
+
+// Sample 一次采样结果
+type Sample struct {
+	Time       time.Time `json:"time"`
+	Goroutines int       `json:"goroutines"`
+	HeapAlloc  uint64    `json:"heapalloc"`  // 堆上正在使用的对象大小(字节)
+	HeapInuse  uint64    `json:"heapinuse"`  // 堆上正在使用的span大小(字节)
+	Sys        uint64    `json:"sys"`        // 从系统申请的内存(字节)
+	NumGC      uint32    `json:"numgc"`      // 累计GC次数
+	GCPauseMax int64     `json:"gcpausemax"` // 距离上次采样的GC中最长的暂停(纳秒)
+	QueueLen   int       `json:"queuelen"`   // MessageDataChan中等待处理的消息数
+	QueueCap   int       `json:"queuecap"`
+	Breaches   []string  `json:"breaches,omitempty"` // 超过阈值的检查项
+}
+
+// Monitor 运行状态监控
+type Monitor struct {
+	mutex       sync.Mutex
+	samples     []Sample             // 最近的采样，从旧到新
+	breached    map[string]bool      // 当前处于超限状态的检查项
+	lastProfile map[string]time.Time // 每类profile上次保存时间
+	lastNumGC   uint32
+	stopChan    chan struct{}
+	captures    map[string]*capture // 正在进行的手动采集，类型 -> 采集
+}
+
+// Start 开始采样，已经开始时不处理
+func (m *Monitor) Start() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stopChan != nil {
+		return
+	}
+	m.stopChan = make(chan struct{})
+	go m.run(m.stopChan)
+}
+
+// Stop 停止采样，结束正在进行的手动采集
+func (m *Monitor) Stop() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.stopCaptures()
+	if m.stopChan == nil {
+		return
+	}
+	close(m.stopChan)
+	m.stopChan = nil
+}
+
+// run 每次采样后按最新配置计算下次采样时间，修改间隔不需要重启
+func (m *Monitor) run(stopChan chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-timer.C:
+			conf := config.NewAppConfig().GetSnapshot().MonitorConfig
+			m.sample(&conf)
+			timer.Reset(time.Duration(conf.Interval) * time.Second)
+		}
+	}
+}
+
+// Samples 最近的采样，从旧到新
+func (m *Monitor) Samples() []Sample {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Sample{}, m.samples...)
+}
+
+// Latest 最近一次采样，还没有采样时返回false
+func (m *Monitor) Latest() (Sample, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.samples) == 0 {
+		return Sample{}, false
+	}
+	return m.samples[len(m.samples)-1], true
+}
+
+// sample 采样一次并检查阈值
+func (m *Monitor) sample(conf *config.MonitorConfig) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	s := Sample{
+		Time:       time.Now(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  stats.HeapAlloc,
+		HeapInuse:  stats.HeapInuse,
+		Sys:        stats.Sys,
+		NumGC:      stats.NumGC,
+		QueueLen:   len(gate.MessageDataChan),
+		QueueCap:   cap(gate.MessageDataChan),
+	}
+
+	// 需要保存的profile在解锁后保存，保存堆profile耗时较长，不阻塞管理接口读取采样
+	var profiles []string
+	defer func() {
+		for _, name := range profiles {
+			m.writeProfile(conf, name, s.Time)
+		}
+	}()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s.GCPauseMax = gcPauseMax(&stats, m.lastNumGC)
+	m.lastNumGC = stats.NumGC
+
+	check := func(name string, breach bool, detail string) {
+		if m.check(conf, &s, name, breach, detail) {
+			profiles = append(profiles, profileName(name))
+		}
+	}
+	check(CheckGoroutines, conf.MaxGoroutines >= 0 && s.Goroutines > conf.MaxGoroutines,
+		fmt.Sprintf("goroutines:%d,max:%d", s.Goroutines, conf.MaxGoroutines))
+	check(CheckHeap, conf.MaxHeapMB >= 0 && s.HeapAlloc > uint64(conf.MaxHeapMB)<<20,
+		fmt.Sprintf("heapalloc:%dMB,max:%dMB", s.HeapAlloc>>20, conf.MaxHeapMB))
+	check(CheckGCPause, conf.MaxGCPauseMs >= 0 && s.GCPauseMax > int64(conf.MaxGCPauseMs)*int64(time.Millisecond),
+		fmt.Sprintf("gcpause:%dms,max:%dms", s.GCPauseMax/int64(time.Millisecond), conf.MaxGCPauseMs))
+	check(CheckQueue, conf.MaxQueuePercent >= 0 && s.QueueCap > 0 && s.QueueLen*100 > s.QueueCap*conf.MaxQueuePercent,
+		fmt.Sprintf("queue:%d/%d,max:%d%%", s.QueueLen, s.QueueCap, conf.MaxQueuePercent))
+
+	m.samples = append(m.samples, s)
+	if over := len(m.samples) - conf.History; over > 0 {
+		m.samples = append(m.samples[:0], m.samples[over:]...)
+	}
+}
+
+// check 检查项进入超限状态时记录警告，恢复时记录一次日志，持续超限时不重复记录
+// 返回是否需要保存profile，同一类profile在ProfileInterval内只保存一次，调用前加锁
+func (m *Monitor) check(conf *config.MonitorConfig, s *Sample, name string, breach bool, detail string) bool {
+	if !breach {
+		if m.breached[name] {
+			delete(m.breached, name)
+			logger.Info("runtime recovered, check:", name, ",", detail)
+		}
+		return false
+	}
+	s.Breaches = append(s.Breaches, name)
+	if m.breached[name] {
+		return false
+	}
+	m.breached[name] = true
+	logger.Warn("runtime over limit, check:", name, ",", detail)
+	if conf.ProfileInterval < 0 {
+		return false
+	}
+	profile := profileName(name)
+	if last, ok := m.lastProfile[profile]; ok && s.Time.Sub(last) < time.Duration(conf.ProfileInterval)*time.Second {
+		return false
+	}
+	m.lastProfile[profile] = s.Time
+	return true
+}
+
+// profileName 协程数和消息队列超限时保存协程profile，内存和GC超限时保存堆profile
+func profileName(check string) string {
+	if check == CheckHeap || check == CheckGCPause {
+		return "heap"
+	}
+	return "goroutine"
+}
+
+// writeProfile 保存profile到ProfileDir，同一类只保留最新的ProfileKeep个，不需要加锁
+func (m *Monitor) writeProfile(conf *config.MonitorConfig, name string, now time.Time) {
+	path, err := WriteProfile(conf.ProfileDir, name, now)
+	if err != nil {
+		logger.Error("write profile failed, name:", name, ",err:", err)
+		return
+	}
+	logger.Warn("write profile, name:", name, ",path:", path)
+	pruneFiles(conf.ProfileDir, name+"-", ".pb.gz", conf.ProfileKeep)
+}
+
+// pruneFiles 删除dir中<prefix><时间><ext>格式的旧文件，只保留最新的keep个
+// 文件名中的时间格式为20060102150405，按文件名排序就是按时间排序
+func pruneFiles(dir, prefix, ext string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Error("prune profile failed, dir:", dir, ",err:", err)
+		return
+	}
+	names := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		if _, err := time.Parse("20060102150405", strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)); err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) <= keep {
+		return
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			logger.Error("prune profile failed, path:", filepath.Join(dir, name), ",err:", err)
+			continue
+		}
+		logger.Info("prune profile, path:", filepath.Join(dir, name))
+	}
+}
+
+// WriteProfile 保存pprof格式的profile到dir目录，文件名为<name>-<时间>.pb.gz，可以用go tool pprof查看
+func WriteProfile(dir, name string, now time.Time) (string, error) {
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		return "", fmt.Errorf("profile not found: %s", name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name+"-"+now.Format("20060102150405")+".pb.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err = profile.WriteTo(file, 0); err != nil {
+		_ = file.Close()
+		return "", err
+	}
+	return path, file.Close()
+}
+
+// gcPauseMax 上次采样之后的GC中最长的暂停，PauseNs只保存最近256次
+func gcPauseMax(stats *runtime.MemStats, lastNumGC uint32) int64 {
+	count := stats.NumGC - lastNumGC
+	if count > uint32(len(stats.PauseNs)) {
+		count = uint32(len(stats.PauseNs))
+	}
+	var pauseMax uint64
+	for i := uint32(0); i < count; i++ {
+		pause := stats.PauseNs[(stats.NumGC-i+255)%256]
+		if pause > pauseMax {
+			pauseMax = pause
+		}
+	}
+	return int64(pauseMax)
+}
@@ -0,0 +1,115 @@
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 定时任务使用的时钟，测试时可以用ManualClock替换
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) ClockTimer
+}
+
+// ClockTimer Clock创建的计时器
+type ClockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock 系统时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) ClockTimer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// ManualClock 手动推进的时钟，调用Advance后到期的计时器才会触发
+// 和系统时钟一样，计时器按经过的时长触发，Set只修改当前时间
+type ManualClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	elapsed time.Duration // 经过的时长，相当于单调时钟
+	timers  []*manualTimer
+}
+
+// NewManualClock 创建从now开始的手动时钟
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) NewTimer(d time.Duration) ClockTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &manualTimer{clock: c, deadline: c.elapsed + d, c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance 时间前进d，触发到期的计时器
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	c.elapsed += d
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.deadline > c.elapsed {
+			timers = append(timers, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = timers
+}
+
+// Set 把当前时间修改为now，可以往回调整，用于模拟系统时间修改，不触发计时器
+func (c *ManualClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+}
+
+type manualTimer struct {
+	clock    *ManualClock
+	deadline time.Duration
+	c        chan time.Time
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
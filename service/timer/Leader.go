@@ -0,0 +1,163 @@
+package timer
+
+import (
+	"pp/common"
+	"pp/config"
+	"pp/db/redis"
+	"pp/service/constant"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 单例定时任务的主服务器选举，同类型服务器通过redis锁选出一个主服务器
+// 主服务器定时续期，续期失败或者进程退出后锁过期，其他服务器在leaderTTL内接替
+
+const (
+	leaderTTL   = 15 * time.Second
+	leaderRenew = 5 * time.Second
+)
+
+// leaderElector 选举状态，redis锁只在选举协程中使用，停止时也由选举协程释放
+type leaderElector struct {
+	isLeader int32
+	stopChan chan struct{}
+	done     chan struct{} // 选举协程退出
+}
+
+// IsLeader 当前服务器是否是单例定时任务的主服务器
+func (t *TickTimerMgr) IsLeader() bool {
+	return atomic.LoadInt32(&t.leader.isLeader) == 1
+}
+
+// startLeader 注册第一个单例任务时开始选举，调用前加锁
+func (t *TickTimerMgr) startLeader() {
+	if t.leader.stopChan != nil {
+		return
+	}
+	t.leader.stopChan = make(chan struct{})
+	t.leader.done = make(chan struct{})
+	go t.runLeader(t.leader.stopChan, t.leader.done)
+}
+
+// StopLeader 停止选举，是主服务器时释放锁让其他服务器立即接替，停服时调用
+// 等待选举协程退出，正在进行的选举结束后才返回
+func (t *TickTimerMgr) StopLeader() {
+	t.mutex.Lock()
+	stopChan, done := t.leader.stopChan, t.leader.done
+	t.leader.stopChan, t.leader.done = nil, nil
+	t.mutex.Unlock()
+	if stopChan == nil {
+		return
+	}
+	close(stopChan)
+	<-done
+}
+
+// runLeader 选举协程，选举和停止都在这里处理，不会在停止后重新成为主服务器
+func (t *TickTimerMgr) runLeader(stopChan, done chan struct{}) {
+	defer close(done)
+	key := constant.GetTimerLeaderKey(config.NewAppConfig().GetSnapshot().ServerType)
+	var lock common.RedLock
+	for {
+		t.electLeader(&lock, key, stopChan)
+		timer := t.clock.NewTimer(leaderRenew)
+		select {
+		case <-stopChan:
+			timer.Stop()
+			if atomic.CompareAndSwapInt32(&t.leader.isLeader, 1, 0) {
+				lock.Unlock()
+				logger.Info("timer leader release, key:", key)
+			}
+			return
+		case <-timer.C():
+		}
+	}
+}
+
+// electLeader 是主服务器时续期，否则尝试成为主服务器，已经停止时不补执行
+func (t *TickTimerMgr) electLeader(lock *common.RedLock, key string, stopChan chan struct{}) {
+	if t.IsLeader() {
+		if !lock.Renew(leaderTTL) {
+			atomic.StoreInt32(&t.leader.isLeader, 0)
+			logger.Warn("timer leader lost, key:", key)
+		}
+		return
+	}
+	if !lock.LockTryOne(key, leaderTTL) {
+		return
+	}
+	atomic.StoreInt32(&t.leader.isLeader, 1)
+	logger.Info("timer leader acquired, key:", key)
+	select {
+	case <-stopChan:
+		return
+	default:
+	}
+	t.catchUpSingleton()
+}
+
+// catchUpSingleton 成为主服务器后，按上次成功执行的计划时间补执行错过的次数
+// 没有执行记录的任务从现在开始记录，不补执行，MissedSkip的任务不补执行
+func (t *TickTimerMgr) catchUpSingleton() {
+	client, index := redis.GetInstance().GetRedisClientByType(redis.RedisTypePlayer)
+	if index == 0 {
+		return
+	}
+	key := constant.GetTimerLastRunKey(config.NewAppConfig().GetSnapshot().ServerType)
+	lastRuns, err := client.HGetAll(key)
+	if err != nil {
+		logger.Error("timer load last run failed, key:", key, ",err:", err)
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	for _, j := range t.jobs {
+		if !j.singleton {
+			continue
+		}
+		lastRun, err := strconv.ParseInt(lastRuns[j.name], 10, 64)
+		if err != nil {
+			// 写入失败时下次成为主服务器再记录
+			_, _ = client.EvalLua(lastRunScript, []string{key}, j.name, now.UnixMilli())
+			continue
+		}
+		if j.missed == MissedSkip {
+			continue
+		}
+		var occurrences []time.Time
+		next := j.schedule.Next(time.UnixMilli(lastRun).In(now.Location()))
+		for !next.IsZero() && next.Before(j.next) && len(occurrences) < maxCatchUp {
+			occurrences = append(occurrences, next)
+			next = j.schedule.Next(next)
+		}
+		if len(occurrences) == 0 {
+			continue
+		}
+		logger.Warn("timer job catch up, name:", j.name, ",lastRun:", time.UnixMilli(lastRun).Format(time.DateTime), ",missed:", len(occurrences))
+		t.trigger(j, occurrences)
+	}
+}
+
+// lastRunScript 计划时间比记录的新时才写入，失去主服务器后执行完的旧任务不会覆盖新主服务器的记录
+const lastRunScript = `
+local last = redis.call("hget", KEYS[1], ARGV[1])
+if last and tonumber(last) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("hset", KEYS[1], ARGV[1], ARGV[2])
+return 1`
+
+// recordLastRun 记录单例任务成功执行的计划时间
+func (t *TickTimerMgr) recordLastRun(name string, scheduled time.Time) {
+	client, index := redis.GetInstance().GetRedisClientByType(redis.RedisTypePlayer)
+	if index == 0 {
+		return
+	}
+	key := constant.GetTimerLastRunKey(config.NewAppConfig().GetSnapshot().ServerType)
+	if _, err := client.EvalLua(lastRunScript, []string{key}, name, scheduled.UnixMilli()); err != nil {
+		logger.Error("timer record last run failed, name:", name, ",err:", err)
+	}
+}
@@ -0,0 +1,92 @@
+package timer
+
+import "sync"
+
+// 按key串行执行的队列，例如按玩家ID或者房间ID，同一个key的任务按投递顺序依次执行
+// 没有任务的key不占用协程和内存，适合大量玩家和房间
+
+const queueShardCount = 64
+
+// QueueGroup 按key串行执行的队列组
+type QueueGroup struct {
+	shards [queueShardCount]queueShard
+}
+
+type queueShard struct {
+	mutex  sync.Mutex
+	queues map[int64]*keyQueue
+}
+
+// keyQueue 一个key等待执行的任务，有任务时有一个协程在执行
+type keyQueue struct {
+	tasks []func()
+}
+
+// NewQueueGroup 创建队列组
+func NewQueueGroup() *QueueGroup {
+	g := &QueueGroup{}
+	for i := range g.shards {
+		g.shards[i].queues = make(map[int64]*keyQueue)
+	}
+	return g
+}
+
+// Queue key对应的队列，可以作为时间轮的Executor
+func (g *QueueGroup) Queue(key int64) Executor {
+	return queueExecutor{group: g, key: key}
+}
+
+// Post 投递到key对应的队列，和同一个key的其他任务串行执行
+func (g *QueueGroup) Post(key int64, fn func()) {
+	shard := g.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if q, ok := shard.queues[key]; ok {
+		q.tasks = append(q.tasks, fn)
+		return
+	}
+	shard.queues[key] = &keyQueue{tasks: []func(){fn}}
+	go g.drain(shard, key)
+}
+
+// Len 有任务的key数量
+func (g *QueueGroup) Len() int {
+	count := 0
+	for i := range g.shards {
+		g.shards[i].mutex.Lock()
+		count += len(g.shards[i].queues)
+		g.shards[i].mutex.Unlock()
+	}
+	return count
+}
+
+func (g *QueueGroup) shard(key int64) *queueShard {
+	return &g.shards[uint64(key)%queueShardCount]
+}
+
+// drain 依次执行key的任务，执行完后删除队列
+func (g *QueueGroup) drain(shard *queueShard, key int64) {
+	for {
+		shard.mutex.Lock()
+		q := shard.queues[key]
+		if len(q.tasks) == 0 {
+			delete(shard.queues, key)
+			shard.mutex.Unlock()
+			return
+		}
+		fn := q.tasks[0]
+		q.tasks[0] = nil
+		q.tasks = q.tasks[1:]
+		shard.mutex.Unlock()
+		runTimerFunc(fn)
+	}
+}
+
+type queueExecutor struct {
+	group *QueueGroup
+	key   int64
+}
+
+func (e queueExecutor) Post(fn func()) {
+	e.group.Post(e.key, fn)
+}
@@ -0,0 +1,63 @@
+package timer
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueGroupOrder(t *testing.T) {
+	group := NewQueueGroup()
+	const keys, count = 10, 1000
+	orders := make([][]int, keys)
+	running := make([]int32, keys)
+	var wg sync.WaitGroup
+	wg.Add(keys * count)
+	for i := 0; i < count; i++ {
+		for key := 0; key < keys; key++ {
+			key, i := key, i
+			group.Post(int64(key), func() {
+				defer wg.Done()
+				// 同一个key不会同时执行
+				if atomic.AddInt32(&running[key], 1) != 1 {
+					t.Errorf("key %d runs concurrently", key)
+				}
+				orders[key] = append(orders[key], i)
+				atomic.AddInt32(&running[key], -1)
+			})
+		}
+	}
+	wg.Wait()
+	for key, order := range orders {
+		if len(order) != count {
+			t.Fatalf("key %d runs %d, want %d", key, len(order), count)
+		}
+		for i, v := range order {
+			if v != i {
+				t.Fatalf("key %d order[%d] = %d", key, i, v)
+			}
+		}
+	}
+
+	// 执行完后删除队列
+	deadline := time.Now().Add(time.Second)
+	for group.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("len = %d, want 0", group.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueGroupPanic(t *testing.T) {
+	group := NewQueueGroup()
+	done := make(chan struct{})
+	group.Post(1, func() { panic("task panic") })
+	group.Post(1, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("task after panic not run")
+	}
+}
@@ -0,0 +1,190 @@
+package timer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule 计算任务的下次执行时间
+type Schedule interface {
+	// Next 返回now之后的下次执行时间，没有下次执行时返回零值
+	Next(now time.Time) time.Time
+	String() string
+}
+
+// everySchedule 固定间隔执行，执行时间对齐到本地时间的整数倍间隔
+// 例如1秒在整秒执行，1分钟在整分执行，1小时在整点执行，不能整除1天的间隔按1970-01-01本地时间对齐
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) Next(now time.Time) time.Time {
+	_, offset := now.Zone()
+	local := now.UnixNano() + int64(offset)*int64(time.Second)
+	next := local - local%int64(s.interval) + int64(s.interval)
+	if local < 0 && local%int64(s.interval) != 0 {
+		next -= int64(s.interval)
+	}
+	return time.Unix(0, next-int64(offset)*int64(time.Second)).In(now.Location())
+}
+
+func (s everySchedule) String() string {
+	return "every " + s.interval.String()
+}
+
+// cronSchedule 标准5段cron表达式：分 时 日 月 周，每段为允许值的位图
+// 支持 * , - / 和 @hourly @daily @weekly @monthly，日和周同时指定时满足其一即可
+type cronSchedule struct {
+	expr   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	anyDom bool // 日为*
+	anyDow bool // 周为*
+}
+
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseCron 解析cron表达式
+func ParseCron(expr string) (Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: need 5 fields, got %d", expr, len(fields))
+	}
+	s := &cronSchedule{expr: expr, anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	bounds := []struct {
+		bits     *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}}
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", expr, err)
+		}
+		*b.bits = bits
+	}
+	// 周日可以写成0或者7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField 解析一段，返回允许值的位图
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			rangePart = part[:i]
+		}
+		start, end := min, max
+		if rangePart != "*" {
+			var err error
+			if i := strings.Index(rangePart, "-"); i >= 0 {
+				start, err = strconv.Atoi(rangePart[:i])
+				if err == nil {
+					end, err = strconv.Atoi(rangePart[i+1:])
+				}
+			} else {
+				start, err = strconv.Atoi(rangePart)
+				// 5/10 表示从5开始到最大值
+				end = start
+				if strings.Contains(part, "/") {
+					end = max
+				}
+			}
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Next 从下一分钟开始逐级查找匹配的月、日、时、分，最多查找5年
+func (s *cronSchedule) Next(now time.Time) time.Time {
+	loc := now.Location()
+	t := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, loc).Add(time.Minute)
+	yearLimit := t.Year() + 5
+	for t.Year() <= yearLimit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatch(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatch 日和周都不是*时满足其一即可，和标准cron一致
+func (s *cronSchedule) dayMatch(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s *cronSchedule) String() string {
+	return "cron " + s.expr
+}
+
+// parseAt 解析hh:mm，转换为每天执行的cron
+func parseAt(hhmm string) (Schedule, error) {
+	at, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return nil, fmt.Errorf("at %q: need hh:mm", hhmm)
+	}
+	s, err := ParseCron(fmt.Sprintf("%d %d * * *", at.Minute(), at.Hour()))
+	if err != nil {
+		return nil, err
+	}
+	s.(*cronSchedule).expr = hhmm
+	return &atSchedule{s.(*cronSchedule)}, nil
+}
+
+// atSchedule 每天固定时间执行
+type atSchedule struct {
+	*cronSchedule
+}
+
+func (s *atSchedule) String() string {
+	return "at " + s.expr
+}
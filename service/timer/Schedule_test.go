@@ -0,0 +1,128 @@
+package timer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	cst = time.FixedZone("CST", 8*3600)      // 整点时区
+	ist = time.FixedZone("IST", 5*3600+1800) // 半点时区，按UTC对齐时整点会差半小时
+)
+
+func TestEveryScheduleAlign(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		now      time.Time
+		want     time.Time
+	}{
+		{time.Second, time.Date(2024, 3, 1, 10, 15, 30, 500, cst), time.Date(2024, 3, 1, 10, 15, 31, 0, cst)},
+		{time.Second, time.Date(2024, 3, 1, 10, 15, 30, 0, cst), time.Date(2024, 3, 1, 10, 15, 31, 0, cst)},
+		{time.Minute, time.Date(2024, 3, 1, 10, 15, 30, 0, cst), time.Date(2024, 3, 1, 10, 16, 0, 0, cst)},
+		{time.Hour, time.Date(2024, 3, 1, 10, 15, 30, 0, cst), time.Date(2024, 3, 1, 11, 0, 0, 0, cst)},
+		{time.Hour, time.Date(2024, 3, 1, 10, 15, 30, 0, ist), time.Date(2024, 3, 1, 11, 0, 0, 0, ist)},
+		{time.Hour, time.Date(2024, 3, 1, 23, 59, 59, 0, ist), time.Date(2024, 3, 2, 0, 0, 0, 0, ist)},
+		{24 * time.Hour, time.Date(2024, 3, 1, 10, 15, 30, 0, cst), time.Date(2024, 3, 2, 0, 0, 0, 0, cst)},
+		{15 * time.Minute, time.Date(2024, 3, 1, 10, 15, 0, 0, ist), time.Date(2024, 3, 1, 10, 30, 0, 0, ist)},
+		// 不能整除1天的间隔按1970-01-01本地时间对齐
+		{7 * time.Minute, time.Date(1970, 1, 1, 0, 6, 0, 0, cst), time.Date(1970, 1, 1, 0, 7, 0, 0, cst)},
+		{7 * time.Minute, time.Date(1970, 1, 1, 0, 7, 0, 0, cst), time.Date(1970, 1, 1, 0, 14, 0, 0, cst)},
+	}
+	for _, tt := range tests {
+		got := everySchedule{interval: tt.interval}.Next(tt.now)
+		if !got.Equal(tt.want) || got.Location() != tt.now.Location() {
+			t.Errorf("every %s Next(%s) = %s, want %s", tt.interval, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronError(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * *", "need 5 fields"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/x * * * *", "invalid step"},
+		{"5-3 * * * *", "out of range"},
+		{"60 * * * *", "out of range"},
+		{"* 24 * * *", "out of range"},
+		{"* * 0 * *", "out of range"},
+		{"* * * 13 *", "out of range"},
+		{"* * * * 8", "out of range"},
+		{"a * * * *", "invalid value"},
+		{"1-x * * * *", "invalid value"},
+		{"@yearly", "need 5 fields"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseCron(tt.expr); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseCron(%q) err = %v, want contains %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-03-01是周五
+	now := time.Date(2024, 3, 1, 10, 15, 30, 0, cst)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 1, 10, 16, 0, 0, cst)},
+		{"*/20 * * * *", time.Date(2024, 3, 1, 10, 20, 0, 0, cst)},
+		{"5/20 * * * *", time.Date(2024, 3, 1, 10, 25, 0, 0, cst)},
+		{"0,15 * * * *", time.Date(2024, 3, 1, 11, 0, 0, 0, cst)},
+		{"30 9-11 * * *", time.Date(2024, 3, 1, 10, 30, 0, 0, cst)},
+		{"0 3 * * *", time.Date(2024, 3, 2, 3, 0, 0, 0, cst)},
+		{"@hourly", time.Date(2024, 3, 1, 11, 0, 0, 0, cst)},
+		{"@daily", time.Date(2024, 3, 2, 0, 0, 0, 0, cst)},
+		{"@weekly", time.Date(2024, 3, 3, 0, 0, 0, 0, cst)},
+		{"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, cst)},
+		// 周日可以写成0或者7
+		{"0 0 * * 7", time.Date(2024, 3, 3, 0, 0, 0, 0, cst)},
+		{"0 0 * * 5-7", time.Date(2024, 3, 2, 0, 0, 0, 0, cst)},
+		// 日和周都指定时满足其一即可：15号或者周一
+		{"0 0 15 * 1", time.Date(2024, 3, 4, 0, 0, 0, 0, cst)},
+		// 只指定日时周为*，只按日匹配
+		{"0 0 15 * *", time.Date(2024, 3, 15, 0, 0, 0, 0, cst)},
+		// 只指定周时日为*，只按周匹配
+		{"0 0 * * 1", time.Date(2024, 3, 4, 0, 0, 0, 0, cst)},
+		// 闰年2月29日
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, cst)},
+	}
+	for _, tt := range tests {
+		schedule, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) failed: %v", tt.expr, err)
+		}
+		if got := schedule.Next(now); !got.Equal(tt.want) {
+			t.Errorf("cron %q Next = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+
+	// 2月31日不存在，5年内找不到时返回零值
+	schedule, _ := ParseCron("0 0 31 2 *")
+	if got := schedule.Next(now); !got.IsZero() {
+		t.Errorf("cron 0 0 31 2 * Next = %s, want zero", got)
+	}
+}
+
+func TestParseAt(t *testing.T) {
+	schedule, err := parseAt("03:30")
+	if err != nil {
+		t.Fatalf("parseAt failed: %v", err)
+	}
+	if schedule.String() != "at 03:30" {
+		t.Fatalf("String = %q", schedule.String())
+	}
+	now := time.Date(2024, 3, 1, 3, 30, 0, 0, cst)
+	if got, want := schedule.Next(now), time.Date(2024, 3, 2, 3, 30, 0, 0, cst); !got.Equal(want) {
+		t.Fatalf("Next = %s, want %s", got, want)
+	}
+	for _, hhmm := range []string{"24:00", "03:60", "0330", "3"} {
+		if _, err := parseAt(hhmm); err == nil {
+			t.Errorf("parseAt(%q) should fail", hhmm)
+		}
+	}
+}
@@ -1,93 +1,359 @@
 package timer
 
 import (
+	"fmt"
+	"pp/common/metrics"
+	"pp/log"
+	"pp/service/cluster"
 	"pp/service/conn"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"time"
 )
 
+// 错过执行时间时的处理方式，进程卡住、系统休眠或者系统时间往前调整后可能同时错过多次执行
+const (
+	MissedRunOnce = iota // 错过的多次合并为一次执行(默认)
+	MissedCatchUp        // 每次都补执行，最多补maxCatchUp次
+	MissedSkip           // 错过一个完整周期以上时不执行，等待下次执行时间
+)
+
+const (
+	maxCatchUp = 100         // 最多补执行的次数
+	maxWait    = time.Second // 最长等待时间，系统时间调整后最多1秒就能发现
+)
+
 var (
 	timerMgr *TickTimerMgr
 	onceMgr  sync.Once
+	logger   = log.GetLogger().Module("timer")
+
+	jobDuration = metrics.NewHistogramVec("pp_timer_job_duration_seconds", "定时任务执行耗时(秒)", nil, "job")
+	jobRuns     = metrics.NewCounterVec("pp_timer_job_runs_total", "定时任务执行次数 result：ok panic skipped", "job", "result")
 )
 
 func GetTickTimerMgr() *TickTimerMgr {
 	onceMgr.Do(func() {
 		if timerMgr == nil {
-			timerMgr = &TickTimerMgr{}
-			now := time.Now()
-			timerMgr.timestamp = now.Unix() - int64(now.Second()) - int64(60*now.Minute())
+			timerMgr = NewTickTimerMgr(realClock{})
+			_ = timerMgr.Every(time.Second, "gate.Timer1s", conn.GetGateClientMgr().Timer1s)
+			_ = timerMgr.Every(cluster.HeartbeatInterval, "cluster.Heartbeat", cluster.GetClusterMgr().Heartbeat)
+			// 整点调用，集群中只在主服务器执行
+			// _ = timerMgr.Every(time.Hour, "rank.FortuneRank", rankService.NewFortuneRank().Timer, WithSingleton())
 		}
 	})
 
 	return timerMgr
 }
 
-// TickTimerMgr 系统消息管理
+// NewTickTimerMgr 创建使用clock的定时任务管理，测试时传入ManualClock
+func NewTickTimerMgr(clock Clock) *TickTimerMgr {
+	return &TickTimerMgr{clock: clock, wake: make(chan struct{}, 1)}
+}
+
+// TickTimerMgr 定时任务管理
+// 任务通过Every、At、Cron注册，每个任务有自己的执行协程，到时间后通知执行
+// 下次执行时间按上次计划时间计算，不会因为执行耗时和调度延迟累积偏差
+// 上次执行还没有结束时跳过本次执行，panic不影响其他任务和下次执行
 type TickTimerMgr struct {
-	timestamp int64
+	mutex  sync.Mutex
+	clock  Clock
+	jobs   []*job
+	wake   chan struct{} // 注册任务后唤醒计时协程重新计算等待时间
+	leader leaderElector // 单例任务的主服务器选举
+}
+
+// job 注册的定时任务
+type job struct {
+	name         string
+	schedule     Schedule
+	fn           func()
+	missed       int           // 错过执行时间时的处理方式
+	singleton    bool          // 是否只在主服务器上执行
+	next         time.Time     // 下次计划执行时间
+	pending      []time.Time   // 等待执行的计划时间
+	running      bool          // 是否正在执行
+	trigger      chan struct{} // 通知执行协程
+	lastRun      time.Time     // 上次开始执行时间
+	lastDuration time.Duration // 上次执行耗时
+	runCount     int64
+	skipCount    int64 // 因为上次执行没有结束或者错过执行时间跳过的次数
+	panicCount   int64
+}
+
+// JobOption 定时任务选项
+type JobOption func(*job)
+
+// WithMissed 设置错过执行时间时的处理方式
+func WithMissed(policy int) JobOption {
+	return func(j *job) {
+		j.missed = policy
+	}
+}
+
+// WithSingleton 集群中同类型服务器只有主服务器执行，主服务器通过redis锁选出
+// 成功执行后记录计划时间，成为主服务器时按错过执行时间的处理方式补执行停机期间错过的次数
+func WithSingleton() JobOption {
+	return func(j *job) {
+		j.singleton = true
+	}
+}
+
+// JobInfo 定时任务状态
+type JobInfo struct {
+	Name         string    `json:"name"`
+	Schedule     string    `json:"schedule"`
+	Singleton    bool      `json:"singleton"`
+	Next         time.Time `json:"next"`
+	Running      bool      `json:"running"`
+	Pending      int       `json:"pending"`
+	LastRun      time.Time `json:"lastrun"`
+	LastDuration int64     `json:"lastduration"` // 上次执行耗时(毫秒)
+	RunCount     int64     `json:"runcount"`
+	SkipCount    int64     `json:"skipcount"`
+	PanicCount   int64     `json:"paniccount"`
+}
+
+// Every 每隔interval执行一次，执行时间对齐到整数倍间隔，例如1小时的任务在整点执行
+func (t *TickTimerMgr) Every(interval time.Duration, name string, fn func(), opts ...JobOption) error {
+	if interval <= 0 {
+		return fmt.Errorf("every %s: interval must be positive", name)
+	}
+	return t.Schedule(everySchedule{interval: interval}, name, fn, opts...)
+}
+
+// At 每天hh:mm执行一次，使用本地时区
+func (t *TickTimerMgr) At(hhmm string, name string, fn func(), opts ...JobOption) error {
+	schedule, err := parseAt(hhmm)
+	if err != nil {
+		return err
+	}
+	return t.Schedule(schedule, name, fn, opts...)
+}
+
+// Cron 按cron表达式执行，见ParseCron
+func (t *TickTimerMgr) Cron(expr string, name string, fn func(), opts ...JobOption) error {
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return err
+	}
+	return t.Schedule(schedule, name, fn, opts...)
+}
+
+// Schedule 按自定义的Schedule执行，name不能重复，用于单例执行的记录和监控指标
+func (t *TickTimerMgr) Schedule(schedule Schedule, name string, fn func(), opts ...JobOption) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, j := range t.jobs {
+		if j.name == name {
+			return fmt.Errorf("timer job %s already registered", name)
+		}
+	}
+	next := schedule.Next(t.now())
+	if next.IsZero() {
+		return fmt.Errorf("%s %s: never runs", name, schedule)
+	}
+	j := &job{name: name, schedule: schedule, fn: fn, next: next, trigger: make(chan struct{}, 1)}
+	for _, opt := range opts {
+		opt(j)
+	}
+	t.jobs = append(t.jobs, j)
+	go t.work(j)
+	if j.singleton {
+		t.startLeader()
+	}
+	t.notifyWake()
+	logger.Info("timer job register, name:", name, ",schedule:", schedule, ",next:", next.Format(time.DateTime))
+	return nil
+}
+
+// Jobs 所有定时任务的状态，按下次执行时间排序
+func (t *TickTimerMgr) Jobs() []JobInfo {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	infoList := make([]JobInfo, 0, len(t.jobs))
+	for _, j := range t.jobs {
+		infoList = append(infoList, JobInfo{
+			Name:         j.name,
+			Schedule:     j.schedule.String(),
+			Singleton:    j.singleton,
+			Next:         j.next,
+			Running:      j.running,
+			Pending:      len(j.pending),
+			LastRun:      j.lastRun,
+			LastDuration: j.lastDuration.Milliseconds(),
+			RunCount:     j.runCount,
+			SkipCount:    j.skipCount,
+			PanicCount:   j.panicCount,
+		})
+	}
+	sort.Slice(infoList, func(i, k int) bool { return infoList[i].Next.Before(infoList[k].Next) })
+	return infoList
 }
 
-// Timer 启动计时器
+// Timer 启动计时器，等待到最近的执行时间，最长等待maxWait后按当前时间重新检查
 func (t *TickTimerMgr) Timer() {
-	interval := time.Millisecond * 10
-	timer := time.NewTimer(interval)
-	var count int64
 	for {
-		select {
-		case <-timer.C:
-			count++
-			if count%100 == 0 {
-				t.Timer1s()
+		t.mutex.Lock()
+		now := t.now()
+		t.runDue(now)
+		wait := maxWait
+		for _, j := range t.jobs {
+			if !j.next.IsZero() && j.next.Sub(now) < wait {
+				wait = j.next.Sub(now)
 			}
+		}
+		t.mutex.Unlock()
 
-			if count%3000 == 0 {
-				t.Timer30s()
-			}
-			if count%6000 == 0 {
-				t.Timer1min()
-			}
+		timer := t.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-t.wake:
+			timer.Stop()
+		}
+	}
+}
 
-			if count%30000 == 0 {
-				t.Timer5min()
-			}
-			if count%360000 == 0 {
-				t.Timer1hour()
-			}
+// now 当前时间，去掉单调时钟读数，按系统时间比较
+func (t *TickTimerMgr) now() time.Time {
+	return t.clock.Now().Round(0)
+}
 
-			timer.Reset(interval)
-		}
+func (t *TickTimerMgr) notifyWake() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
 	}
 }
 
-// Timer1s 1秒执行一次
-func (t *TickTimerMgr) Timer1s() {
-	go conn.GetGateClientMgr().Timer1s()
-	now := time.Now()
-	if now.Unix()-t.timestamp >= 3600 {
-		t.everyHourTimer()
-		nowTime := time.Now()
-		t.timestamp = nowTime.Unix() - int64(nowTime.Second()) - int64(60*nowTime.Minute())
+// runDue 通知到时间的任务执行，调用前加锁
+func (t *TickTimerMgr) runDue(now time.Time) {
+	for _, j := range t.jobs {
+		// 系统时间往回调整后按当前时间重新计算，避免等待回退的时长
+		if next := j.schedule.Next(now); !next.IsZero() && next.Before(j.next) {
+			logger.Warn("timer job clock moved back, name:", j.name, ",next:", j.next.Format(time.DateTime), ",newNext:", next.Format(time.DateTime))
+			j.next = next
+		}
+		if j.next.IsZero() || j.next.After(now) {
+			continue
+		}
+		// 从上次计划时间计算下次执行时间，错过的计划时间最多记录maxCatchUp次
+		occurrences := []time.Time{j.next}
+		next := j.schedule.Next(j.next)
+		for !next.IsZero() && !next.After(now) {
+			if len(occurrences) >= maxCatchUp {
+				next = j.schedule.Next(now)
+				break
+			}
+			occurrences = append(occurrences, next)
+			next = j.schedule.Next(next)
+		}
+		j.next = next
+		// 不是主服务器时不执行单例任务，成为主服务器时按记录的上次执行时间补执行
+		if j.singleton && !t.IsLeader() {
+			continue
+		}
+		t.trigger(j, occurrences)
 	}
 }
 
-// Timer1min 1分钟执行一次
-func (t *TickTimerMgr) Timer1min() {
+// trigger 按错过执行时间的处理方式通知执行，occurrences为到期的计划时间，调用前加锁
+func (t *TickTimerMgr) trigger(j *job, occurrences []time.Time) {
+	runs := occurrences
+	if len(occurrences) > 1 {
+		switch j.missed {
+		case MissedCatchUp:
+		case MissedSkip:
+			runs = nil
+		default:
+			runs = occurrences[len(occurrences)-1:]
+		}
+		logger.Warn("timer job missed, name:", j.name, ",scheduled:", occurrences[0].Format(time.DateTime), ",missed:", len(occurrences)-1, ",runs:", len(runs))
+	}
+	if skipped := len(occurrences) - len(runs); skipped > 0 {
+		j.skipCount += int64(skipped)
+		jobRuns.With(j.name, "skipped").Add(float64(skipped))
+	}
+	if len(runs) == 0 {
+		return
+	}
+	if j.missed != MissedCatchUp && (j.running || len(j.pending) > 0) {
+		j.skipCount++
+		jobRuns.With(j.name, "skipped").Inc()
+		logger.Warn("timer job skipped, last run not finished, name:", j.name, ",lastRun:", j.lastRun.Format(time.DateTime))
+		return
+	}
+	for _, scheduled := range runs {
+		t.addPending(j, scheduled)
+	}
 }
 
-// Timer5min 5分钟执行一次
-func (t *TickTimerMgr) Timer5min() {
+// addPending 添加一次等待执行，最多等待maxCatchUp次，调用前加锁
+func (t *TickTimerMgr) addPending(j *job, scheduled time.Time) {
+	if len(j.pending) >= maxCatchUp {
+		j.skipCount++
+		jobRuns.With(j.name, "skipped").Inc()
+		return
+	}
+	j.pending = append(j.pending, scheduled)
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+	}
 }
 
-// Timer30s 30秒执行一次
-func (t *TickTimerMgr) Timer30s() {
+// work 任务的执行协程，依次执行等待的次数
+func (t *TickTimerMgr) work(j *job) {
+	for range j.trigger {
+		for {
+			scheduled, ok := t.takePending(j)
+			if !ok {
+				break
+			}
+			if t.run(j) && j.singleton {
+				t.recordLastRun(j.name, scheduled)
+			}
+		}
+	}
 }
 
-// Timer1hour 1小时执行一次
-func (t *TickTimerMgr) Timer1hour() {
+func (t *TickTimerMgr) takePending(j *job) (time.Time, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(j.pending) == 0 {
+		j.running = false
+		return time.Time{}, false
+	}
+	scheduled := j.pending[0]
+	j.pending = j.pending[1:]
+	j.running = true
+	j.lastRun = t.now()
+	return scheduled, true
 }
 
-// 整点调用
-func (t *TickTimerMgr) everyHourTimer() {
-	// go rankService.NewFortuneRank().Timer()
+// run 执行任务，记录耗时，panic不影响其他任务，返回是否成功执行
+func (t *TickTimerMgr) run(j *job) (ok bool) {
+	start := time.Now()
+	result := "ok"
+	defer func() {
+		if err := recover(); err != nil {
+			result = "panic"
+			logger.Error("timer job panic, name:", j.name, ",err:", err, ",stack:", string(debug.Stack()))
+		}
+		duration := time.Since(start)
+		jobDuration.With(j.name).Observe(duration.Seconds())
+		jobRuns.With(j.name, result).Inc()
+
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		j.lastDuration = duration
+		j.runCount++
+		if result == "panic" {
+			j.panicCount++
+		}
+		ok = result == "ok"
+	}()
+	j.fn()
+	return true
 }
@@ -0,0 +1,148 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+// tick 按时钟当前时间检查一次到期任务，相当于Timer循环中的一次唤醒
+func tick(mgr *TickTimerMgr) {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	mgr.runDue(mgr.now())
+}
+
+// waitIdle 等待任务执行协程执行完所有等待的次数
+func waitIdle(t *testing.T, mgr *TickTimerMgr) JobInfo {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		info := mgr.Jobs()[0]
+		if !info.Running && info.Pending == 0 {
+			return info
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job not finished: %+v", info)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMissedPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   int
+		wantRuns int64
+		wantSkip int64
+	}{
+		{"runonce", MissedRunOnce, 1, 4},
+		{"catchup", MissedCatchUp, 5, 0},
+		{"skip", MissedSkip, 0, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Date(2024, 3, 1, 10, 0, 30, 0, cst))
+			mgr := NewTickTimerMgr(clock)
+			if err := mgr.Every(time.Minute, "missed."+tt.name, func() {}, WithMissed(tt.policy)); err != nil {
+				t.Fatalf("Every failed: %v", err)
+			}
+			// 卡住5分钟，错过10:01到10:05共5次
+			clock.Advance(5 * time.Minute)
+			tick(mgr)
+			info := waitIdle(t, mgr)
+			if info.RunCount != tt.wantRuns || info.SkipCount != tt.wantSkip {
+				t.Fatalf("runs = %d, skips = %d, want %d, %d", info.RunCount, info.SkipCount, tt.wantRuns, tt.wantSkip)
+			}
+			if want := time.Date(2024, 3, 1, 10, 6, 0, 0, cst); !info.Next.Equal(want) {
+				t.Fatalf("next = %s, want %s", info.Next, want)
+			}
+
+			// 恢复后按正常周期执行
+			clock.Advance(time.Minute)
+			tick(mgr)
+			if info = waitIdle(t, mgr); info.RunCount != tt.wantRuns+1 {
+				t.Fatalf("runs = %d, want %d", info.RunCount, tt.wantRuns+1)
+			}
+		})
+	}
+}
+
+func TestClockMovedBack(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 3, 1, 10, 30, 0, 0, cst))
+	mgr := NewTickTimerMgr(clock)
+	_ = mgr.Every(time.Hour, "movedback", func() {})
+	if next := mgr.Jobs()[0].Next; !next.Equal(time.Date(2024, 3, 1, 11, 0, 0, 0, cst)) {
+		t.Fatalf("next = %s", next)
+	}
+
+	// 系统时间往回调整2小时，按当前时间重新计算下次执行时间，不执行
+	clock.Set(time.Date(2024, 3, 1, 8, 30, 0, 0, cst))
+	tick(mgr)
+	info := waitIdle(t, mgr)
+	if want := time.Date(2024, 3, 1, 9, 0, 0, 0, cst); !info.Next.Equal(want) || info.RunCount != 0 {
+		t.Fatalf("next = %s, runs = %d, want %s, 0", info.Next, info.RunCount, want)
+	}
+
+	clock.Set(time.Date(2024, 3, 1, 9, 0, 0, 0, cst))
+	tick(mgr)
+	info = waitIdle(t, mgr)
+	if want := time.Date(2024, 3, 1, 10, 0, 0, 0, cst); !info.Next.Equal(want) || info.RunCount != 1 || info.SkipCount != 0 {
+		t.Fatalf("next = %s, runs = %d, skips = %d, want %s, 1, 0", info.Next, info.RunCount, info.SkipCount, want)
+	}
+}
+
+func TestSkipWhenRunning(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 3, 1, 10, 0, 0, 0, cst))
+	mgr := NewTickTimerMgr(clock)
+	release := make(chan struct{})
+	_ = mgr.Every(time.Minute, "running", func() { <-release })
+
+	clock.Advance(time.Minute)
+	tick(mgr)
+	// 上次执行还没有结束，跳过本次
+	clock.Advance(time.Minute)
+	tick(mgr)
+	close(release)
+	info := waitIdle(t, mgr)
+	if info.RunCount != 1 || info.SkipCount != 1 {
+		t.Fatalf("runs = %d, skips = %d, want 1, 1", info.RunCount, info.SkipCount)
+	}
+}
+
+func TestJobPanic(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 3, 1, 10, 0, 0, 0, cst))
+	mgr := NewTickTimerMgr(clock)
+	_ = mgr.Every(time.Minute, "panic", func() { panic("job panic") })
+
+	for i := 0; i < 2; i++ {
+		clock.Advance(time.Minute)
+		tick(mgr)
+		waitIdle(t, mgr)
+	}
+	if info := mgr.Jobs()[0]; info.RunCount != 2 || info.PanicCount != 2 {
+		t.Fatalf("runs = %d, panics = %d, want 2, 2", info.RunCount, info.PanicCount)
+	}
+}
+
+func TestScheduleNeverRuns(t *testing.T) {
+	mgr := NewTickTimerMgr(NewManualClock(time.Date(2024, 3, 1, 10, 0, 0, 0, cst)))
+	if err := mgr.Cron("0 0 31 2 *", "never", func() {}); err == nil {
+		t.Fatal("schedule never runs should fail")
+	}
+	if err := mgr.Every(0, "zero", func() {}); err == nil {
+		t.Fatal("zero interval should fail")
+	}
+}
+
+func TestScheduleDuplicateName(t *testing.T) {
+	mgr := NewTickTimerMgr(NewManualClock(time.Date(2024, 3, 1, 10, 0, 0, 0, cst)))
+	if err := mgr.Every(time.Minute, "dup", func() {}); err != nil {
+		t.Fatalf("Every failed: %v", err)
+	}
+	if err := mgr.At("03:00", "dup", func() {}); err == nil {
+		t.Fatal("duplicate name should fail")
+	}
+	if n := len(mgr.Jobs()); n != 1 {
+		t.Fatalf("jobs = %d, want 1", n)
+	}
+}
@@ -0,0 +1,301 @@
+package timer
+
+import (
+	"pp/common/metrics"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 分层时间轮，用于大量短时定时器，例如回合超时、房间过期、buff时长
+// 第0层256格，第1-4层各64格，精度为tick，10ms精度时最长约497天，超过的按最长处理
+// 添加、取消、重置都是O(1)，到期后在新协程中执行，或者投递到指定的执行队列
+
+const (
+	wheelRootBits  = 8
+	wheelLevelBits = 6
+	wheelLevels    = 4 // 第0层之外的层数
+	wheelRootSize  = 1 << wheelRootBits
+	wheelLevelSize = 1 << wheelLevelBits
+	wheelMaxTicks  = 1<<(wheelRootBits+wheelLevels*wheelLevelBits) - 1
+)
+
+var (
+	timerWheel     atomic.Pointer[TimerWheel] // 指标采集协程也会读取
+	timerWheelOnce sync.Once
+)
+
+func init() {
+	metrics.NewGaugeFunc("pp_timer_wheel_timers", "时间轮中等待到期的定时器数", func() float64 {
+		w := timerWheel.Load()
+		if w == nil {
+			return 0
+		}
+		return float64(w.Len())
+	})
+}
+
+// GetTimerWheel 10ms精度的全局时间轮，第一次调用时开始计时
+func GetTimerWheel() *TimerWheel {
+	timerWheelOnce.Do(func() {
+		w := NewTimerWheel(10*time.Millisecond, realClock{})
+		w.Start()
+		timerWheel.Store(w)
+	})
+	return timerWheel.Load()
+}
+
+// TimerID 时间轮定时器ID，从1开始递增，0表示无效
+type TimerID uint64
+
+// Executor 定时器到期后的执行队列
+type Executor interface {
+	Post(fn func())
+}
+
+// wheelTimer 时间轮中的定时器，挂在所在格子的双向链表上
+type wheelTimer struct {
+	id         TimerID
+	expire     uint64 // 到期的tick
+	fn         func()
+	executor   Executor
+	prev, next *wheelTimer
+	list       *timerList
+}
+
+// timerList 一个格子中的定时器
+type timerList struct {
+	head wheelTimer // 哨兵
+}
+
+func (l *timerList) init() {
+	l.head.prev = &l.head
+	l.head.next = &l.head
+}
+
+func (l *timerList) push(t *wheelTimer) {
+	t.prev = l.head.prev
+	t.next = &l.head
+	l.head.prev.next = t
+	l.head.prev = t
+	t.list = l
+}
+
+func (l *timerList) remove(t *wheelTimer) {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev, t.next, t.list = nil, nil, nil
+}
+
+// take 取出格子中所有定时器
+func (l *timerList) take() []*wheelTimer {
+	var timers []*wheelTimer
+	for t := l.head.next; t != &l.head; {
+		next := t.next
+		t.prev, t.next, t.list = nil, nil, nil
+		timers = append(timers, t)
+		t = next
+	}
+	l.init()
+	return timers
+}
+
+// TimerWheel 分层时间轮
+type TimerWheel struct {
+	mutex    sync.Mutex
+	tick     time.Duration
+	clock    Clock
+	start    time.Time // 第0个tick的时间
+	current  uint64    // 已经处理到的tick
+	root     [wheelRootSize]timerList
+	levels   [wheelLevels][wheelLevelSize]timerList
+	timers   map[TimerID]*wheelTimer
+	lastID   TimerID
+	stopChan chan struct{}
+}
+
+// NewTimerWheel 创建精度为tick的时间轮，需要调用Start后才会计时，测试时可以传入ManualClock
+func NewTimerWheel(tick time.Duration, clock Clock) *TimerWheel {
+	w := &TimerWheel{tick: tick, clock: clock, start: clock.Now(), timers: make(map[TimerID]*wheelTimer)}
+	for i := range w.root {
+		w.root[i].init()
+	}
+	for level := range w.levels {
+		for i := range w.levels[level] {
+			w.levels[level][i].init()
+		}
+	}
+	return w
+}
+
+// Start 开始计时，已经开始时不处理
+func (w *TimerWheel) Start() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopChan != nil {
+		return
+	}
+	w.stopChan = make(chan struct{})
+	go w.run(w.stopChan)
+}
+
+// Stop 停止计时，未到期的定时器保留，再次Start后继续计时
+func (w *TimerWheel) Stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopChan == nil {
+		return
+	}
+	close(w.stopChan)
+	w.stopChan = nil
+}
+
+// AfterFunc d之后在新协程中执行fn
+func (w *TimerWheel) AfterFunc(d time.Duration, fn func()) TimerID {
+	return w.AfterFuncOn(d, nil, fn)
+}
+
+// AfterFuncOn d之后把fn投递到executor执行，executor为nil时在新协程中执行
+// 需要和玩家或者房间的其他处理串行时，传入QueueGroup.Queue返回的队列
+func (w *TimerWheel) AfterFuncOn(d time.Duration, executor Executor, fn func()) TimerID {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.lastID++
+	t := &wheelTimer{id: w.lastID, fn: fn, executor: executor}
+	t.expire = w.expireTick(d)
+	w.timers[t.id] = t
+	w.add(t)
+	return t.id
+}
+
+// Cancel 取消定时器，已经到期或者不存在时返回false
+func (w *TimerWheel) Cancel(id TimerID) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	t, ok := w.timers[id]
+	if !ok {
+		return false
+	}
+	delete(w.timers, id)
+	t.list.remove(t)
+	return true
+}
+
+// Reset 把定时器改为从现在开始d之后到期，已经到期或者不存在时返回false
+func (w *TimerWheel) Reset(id TimerID, d time.Duration) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	t, ok := w.timers[id]
+	if !ok {
+		return false
+	}
+	t.list.remove(t)
+	t.expire = w.expireTick(d)
+	w.add(t)
+	return true
+}
+
+// Len 等待到期的定时器数
+func (w *TimerWheel) Len() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.timers)
+}
+
+// expireTick 按当前时间计算到期的tick，保证不会提前到期，调用前加锁
+func (w *TimerWheel) expireTick(d time.Duration) uint64 {
+	elapsed := w.clock.Now().Sub(w.start) + d
+	expire := uint64((elapsed + w.tick - 1) / w.tick)
+	if elapsed < 0 || expire <= w.current {
+		expire = w.current + 1
+	}
+	if expire-w.current > wheelMaxTicks {
+		expire = w.current + wheelMaxTicks
+	}
+	return expire
+}
+
+// add 按距离到期的tick数放到对应层的格子中，调用前加锁
+func (w *TimerWheel) add(t *wheelTimer) {
+	delta := t.expire - w.current
+	if delta < wheelRootSize {
+		w.root[t.expire&(wheelRootSize-1)].push(t)
+		return
+	}
+	for level := 0; level < wheelLevels; level++ {
+		shift := wheelRootBits + level*wheelLevelBits
+		if delta < 1<<(shift+wheelLevelBits) || level == wheelLevels-1 {
+			w.levels[level][(t.expire>>shift)&(wheelLevelSize-1)].push(t)
+			return
+		}
+	}
+}
+
+// run 每个tick按经过的时间推进，卡住后一次推进多个tick
+func (w *TimerWheel) run(stopChan chan struct{}) {
+	for {
+		timer := w.clock.NewTimer(w.tick)
+		select {
+		case <-stopChan:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		w.advance()
+	}
+}
+
+// advance 推进到当前时间，执行到期的定时器
+func (w *TimerWheel) advance() {
+	w.mutex.Lock()
+	target := uint64(0)
+	if elapsed := w.clock.Now().Sub(w.start); elapsed > 0 {
+		target = uint64(elapsed / w.tick)
+	}
+	var expired []*wheelTimer
+	for w.current < target {
+		expired = append(expired, w.tickOnce()...)
+	}
+	w.mutex.Unlock()
+
+	for _, t := range expired {
+		if t.executor != nil {
+			t.executor.Post(t.fn)
+		} else {
+			go runTimerFunc(t.fn)
+		}
+	}
+}
+
+// tickOnce 推进一个tick，第0层转完一圈时把上层对应格子的定时器重新放到下层，返回到期的定时器
+func (w *TimerWheel) tickOnce() []*wheelTimer {
+	w.current++
+	index := w.current & (wheelRootSize - 1)
+	if index == 0 {
+		for level := 0; level < wheelLevels; level++ {
+			levelIndex := (w.current >> (wheelRootBits + level*wheelLevelBits)) & (wheelLevelSize - 1)
+			for _, t := range w.levels[level][levelIndex].take() {
+				w.add(t)
+			}
+			if levelIndex != 0 {
+				break
+			}
+		}
+	}
+	expired := w.root[index].take()
+	for _, t := range expired {
+		delete(w.timers, t.id)
+	}
+	return expired
+}
+
+// runTimerFunc 执行定时器函数，panic不影响其他定时器
+func runTimerFunc(fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("timer func panic, err:", err, ",stack:", string(debug.Stack()))
+		}
+	}()
+	fn()
+}
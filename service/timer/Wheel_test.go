@@ -0,0 +1,204 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+const testTick = 10 * time.Millisecond
+
+// recordExecutor 在推进时间轮的协程中直接执行，按到期顺序记录
+type recordExecutor struct {
+	fired []int
+}
+
+func (e *recordExecutor) Post(fn func()) {
+	fn()
+}
+
+func newTestWheel() (*TimerWheel, *ManualClock, *recordExecutor) {
+	clock := NewManualClock(time.Date(2024, 3, 1, 10, 0, 0, 0, cst))
+	return NewTimerWheel(testTick, clock), clock, &recordExecutor{}
+}
+
+// advanceTicks 时钟前进n个tick后推进时间轮，不启动计时协程
+func advanceTicks(w *TimerWheel, clock *ManualClock, n int) {
+	clock.Advance(time.Duration(n) * testTick)
+	w.advance()
+}
+
+func (e *recordExecutor) add(w *TimerWheel, ticks int, label int) TimerID {
+	return w.AfterFuncOn(time.Duration(ticks)*testTick, e, func() { e.fired = append(e.fired, label) })
+}
+
+func TestWheelCascade(t *testing.T) {
+	w, clock, executor := newTestWheel()
+	// 第0层、第1层、第2层以及跨层边界的到期时间
+	expires := []int{1, 255, 256, 257, 300, 16383, 16384, 16385, 20000, 1<<20 + 5}
+	for _, ticks := range expires {
+		executor.add(w, ticks, ticks)
+	}
+	// 当前tick不在0时加入，到期时间和格子边界不对齐
+	advanceTicks(w, clock, 100)
+	executor.add(w, 16384+50, 100+16384+50)
+	executor.add(w, 201, 100+201)
+	expires = []int{1, 255, 256, 257, 300, 301, 16383, 16384, 16385, 16534, 20000, 1<<20 + 5}
+
+	current := 100
+	executor.fired = executor.fired[:0]
+	for _, expire := range expires {
+		if expire <= current {
+			continue
+		}
+		// 到期前一个tick不执行，到期时执行
+		advanceTicks(w, clock, expire-1-current)
+		if len(executor.fired) != 0 {
+			t.Fatalf("tick %d: fired %v before expire %d", expire-1, executor.fired, expire)
+		}
+		advanceTicks(w, clock, 1)
+		current = expire
+		if len(executor.fired) != 1 || executor.fired[0] != expire {
+			t.Fatalf("tick %d: fired %v, want [%d]", expire, executor.fired, expire)
+		}
+		executor.fired = executor.fired[:0]
+	}
+	if w.Len() != 0 {
+		t.Fatalf("len = %d, want 0", w.Len())
+	}
+}
+
+func TestWheelStall(t *testing.T) {
+	w, clock, executor := newTestWheel()
+	for _, ticks := range []int{20000, 3, 300, 3, 1} {
+		executor.add(w, ticks, ticks)
+	}
+	// 卡住后一次推进多个tick，按到期顺序执行，同一tick按加入顺序执行
+	advanceTicks(w, clock, 30000)
+	want := []int{1, 3, 3, 300, 20000}
+	if len(executor.fired) != len(want) {
+		t.Fatalf("fired %v, want %v", executor.fired, want)
+	}
+	for i := range want {
+		if executor.fired[i] != want[i] {
+			t.Fatalf("fired %v, want %v", executor.fired, want)
+		}
+	}
+}
+
+func TestWheelCancelReset(t *testing.T) {
+	w, clock, executor := newTestWheel()
+	canceled := executor.add(w, 10, 1)
+	reset := executor.add(w, 10, 2)
+	expired := executor.add(w, 5, 3)
+	if !w.Cancel(canceled) || w.Cancel(canceled) || w.Cancel(0) {
+		t.Fatal("cancel should succeed only once")
+	}
+	if !w.Reset(reset, 300*testTick) {
+		t.Fatal("reset failed")
+	}
+
+	advanceTicks(w, clock, 10)
+	if len(executor.fired) != 1 || executor.fired[0] != 3 {
+		t.Fatalf("fired %v, want [3]", executor.fired)
+	}
+	// 已经到期的定时器不能取消和重置
+	if w.Cancel(expired) || w.Reset(expired, testTick) {
+		t.Fatal("cancel or reset expired timer should fail")
+	}
+	advanceTicks(w, clock, 1)
+	if w.Len() != 1 || len(executor.fired) != 1 {
+		t.Fatalf("len = %d, fired %v", w.Len(), executor.fired)
+	}
+
+	// 重置后从重置时开始计算，300个tick先放在第1层的格子
+	advanceTicks(w, clock, 288)
+	if len(executor.fired) != 1 {
+		t.Fatalf("fired %v before reset expire", executor.fired)
+	}
+	advanceTicks(w, clock, 1)
+	if len(executor.fired) != 2 || executor.fired[1] != 2 || w.Len() != 0 {
+		t.Fatalf("fired %v, len = %d", executor.fired, w.Len())
+	}
+	if w.Reset(reset, testTick) {
+		t.Fatal("reset expired timer should fail")
+	}
+}
+
+func TestWheelMaxTicks(t *testing.T) {
+	w, _, executor := newTestWheel()
+	id := executor.add(w, wheelMaxTicks+1000, 1)
+	w.mutex.Lock()
+	expire := w.timers[id].expire
+	w.mutex.Unlock()
+	if expire != wheelMaxTicks {
+		t.Fatalf("expire = %d, want %d", expire, wheelMaxTicks)
+	}
+}
+
+func TestWheelQueueOrder(t *testing.T) {
+	w, clock, _ := newTestWheel()
+	group := NewQueueGroup()
+	done := make(chan struct{})
+	var order []int
+	const count = 100
+	for i := 0; i < count; i++ {
+		i := i
+		w.AfterFuncOn(5*testTick, group.Queue(1), func() {
+			order = append(order, i)
+			if i == count-1 {
+				close(done)
+			}
+		})
+	}
+	advanceTicks(w, clock, 5)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timers not fired")
+	}
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("order[%d] = %d", i, v)
+		}
+	}
+}
+
+func BenchmarkWheelAfterFunc(b *testing.B) {
+	w := NewTimerWheel(testTick, realClock{})
+	fn := func() {}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Cancel(w.AfterFunc(time.Second, fn))
+	}
+}
+
+func BenchmarkTimeAfterFunc(b *testing.B) {
+	fn := func() {}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		time.AfterFunc(time.Second, fn).Stop()
+	}
+}
+
+func BenchmarkWheelAfterFuncParallel(b *testing.B) {
+	w := NewTimerWheel(testTick, realClock{})
+	fn := func() {}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w.Cancel(w.AfterFunc(time.Second, fn))
+		}
+	})
+}
+
+func BenchmarkTimeAfterFuncParallel(b *testing.B) {
+	fn := func() {}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			time.AfterFunc(time.Second, fn).Stop()
+		}
+	})
+}
@@ -0,0 +1,8 @@
+//go:build windows || plan9
+
+package main
+
+import "os"
+
+// captureSignals 没有USR1和USR2信号，只能通过管理接口采集
+var captureSignals = map[os.Signal]string{}
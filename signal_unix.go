@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"pp/service/monitor"
+	"syscall"
+)
+
+// captureSignals 触发手动采集的信号，kill -USR1 采集cpu，kill -USR2 采集trace
+var captureSignals = map[os.Signal]string{
+	syscall.SIGUSR1: monitor.CaptureCPU,
+	syscall.SIGUSR2: monitor.CaptureTrace,
+}